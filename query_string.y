@@ -1,20 +1,27 @@
 %{
 package querystr
 
-import(
-    "github.com/blugelabs/bluge"
-)
 %}
 
 %union {
 s string
 n int
 f float64
-q bluge.Query
-pf *float64}
+node Node
+bn *BooleanNode
+c *queryStringClause
+o Occur
+rb *RangeBound
+b bool
+pf *float64
+lit bool
+rn *RangeNode
+pos int
+end int}
 
 %token tSTRING tPHRASE tPLUS tMINUS tCOLON tBOOST tNUMBER tSTRING tGREATER tLESS
-tEQUAL tTILDE
+tEQUAL tTILDE tLEFTPAREN tRIGHTPAREN tAND tOR tNOT tLEFTBRACKET tRIGHTBRACKET
+tLEFTBRACE tRIGHTBRACE tTO tMINSHOULD
 
 %type <s>                tSTRING
 %type <s>                tPHRASE
@@ -22,189 +29,258 @@ tEQUAL tTILDE
 %type <s>                posOrNegNumber
 %type <s>                tTILDE
 %type <s>                tBOOST
-%type <q>                searchBase
+%type <s>                tMINSHOULD
+%type <s>                searchMinShould
+%type <node>                searchBase
+%type <bn>                searchParts
+%type <c>                searchOr
+%type <c>                searchAnd
+%type <c>                searchNot
+%type <c>                searchPart
+%type <rn>                searchRange
+%type <rb>                rangeBound
+%type <b>                rangeStart
+%type <b>                rangeEnd
 %type <pf>                searchSuffix
-%type <n>                searchPrefix
+%type <o>                searchPrefix
 
 %%
 
 input:
 searchParts {
 	yylex.(*lexerWrapper).logDebugGrammarf("INPUT")
+	$1.MinShould = yylex.(*lexerWrapper).opt.minShould
+	yylex.(*lexerWrapper).checkRanges($1)
+	yylex.(*lexerWrapper).checkLimits($1)
+	yylex.(*lexerWrapper).root = $1
 };
 
 searchParts:
-searchPart searchParts {
+searchParts searchOr {
 	yylex.(*lexerWrapper).logDebugGrammarf("SEARCH PARTS")
+	$$ = queryStringAddClause($1, $2)
 }
 |
-searchPart {
+searchOr {
 	yylex.(*lexerWrapper).logDebugGrammarf("SEARCH PART")
+	$$ = queryStringAddClause(&BooleanNode{}, $1)
+};
+
+searchOr:
+searchOr tOR searchAnd {
+	yylex.(*lexerWrapper).logDebugGrammarf("OR")
+	$$ = queryStringCombineClauses(queryOr, yylex.(*lexerWrapper).defaultOccur(), $1, $3)
+	$$.Node.(*BooleanNode).MinShould = yylex.(*lexerWrapper).opt.minShould
+}
+|
+searchAnd {
+	$$ = $1
+};
+
+searchAnd:
+searchAnd tAND searchNot {
+	yylex.(*lexerWrapper).logDebugGrammarf("AND")
+	$$ = queryStringCombineClauses(queryAnd, yylex.(*lexerWrapper).defaultOccur(), $1, $3)
+}
+|
+searchNot {
+	$$ = $1
+};
+
+searchNot:
+tNOT searchNot {
+	yylex.(*lexerWrapper).logDebugGrammarf("NOT")
+	$$ = queryStringNegateClause($2)
+}
+|
+searchPart {
+	$$ = $1
 };
 
 searchPart:
 searchPrefix searchBase searchSuffix {
-    q := $2
-    if $3 != nil {
-        var err error
-        q, err = queryStringSetBoost($2, *$3)
-        if err != nil {
-          yylex.(*lexerWrapper).lex.Error(err.Error())
-        }
-    }
-	switch($1) {
-		case queryShould:
-			yylex.(*lexerWrapper).query.AddShould(q)
-		case queryMust:
-			yylex.(*lexerWrapper).query.AddMust(q)
-		case queryMustNot:
-			yylex.(*lexerWrapper).query.AddMustNot(q)
-	}
+	yylex.(*lexerWrapper).checkFeatures($2, $<pos>2)
+	boost := yylex.(*lexerWrapper).checkBoost($2, $3, $<pos>3, $<end>3)
+	$$ = &queryStringClause{Clause: &Clause{Occur: $1, Node: $2, Boost: boost}}
+}
+|
+error {
+	// parsing carries on after a syntax error, to find any others
+	// in the same pass when lenient, the tree is not used
+	$$ = &queryStringClause{Clause: &Clause{Occur: OccurShould, Node: &BooleanNode{}}}
 };
 
 
 searchPrefix:
 /* empty */ {
-	$$ = queryShould
+	$$ = yylex.(*lexerWrapper).defaultOccur()
 }
 |
 tPLUS {
 	yylex.(*lexerWrapper).logDebugGrammarf("PLUS")
-	$$ = queryMust
+	$$ = OccurMust
 }
 |
 tMINUS {
 	yylex.(*lexerWrapper).logDebugGrammarf("MINUS")
-	$$ = queryMustNot
+	$$ = OccurMustNot
 };
 
 searchBase:
+tLEFTPAREN searchParts tRIGHTPAREN searchMinShould {
+	yylex.(*lexerWrapper).logDebugGrammarf("GROUP")
+	$2.Span = Span{Start: $<pos>1, End: $<end>3}
+	$2.MinShould = $4
+	$$ = $2
+}
+|
 tSTRING {
-    yylex.(*lexerWrapper).logDebugGrammarf("STRING - %s", $1)
-	$$ = queryStringStringToken(yylex, "", $1)
+	yylex.(*lexerWrapper).logDebugGrammarf("STRING - %s", $1)
+	$$ = queryStringStringNode("", $1, $<lit>1, Span{Start: $<pos>1, End: $<end>1})
 }
 |
 tSTRING tTILDE {
-    yylex.(*lexerWrapper).logDebugGrammarf("FUZZY STRING - %s %s", $1, $2)
-	q, err := queryStringStringTokenFuzzy(yylex, "", $1, $2)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-	$$ = q
+	yylex.(*lexerWrapper).logDebugGrammarf("FUZZY STRING - %s %s", $1, $2)
+	fuzziness, err := queryStringParseFuzziness($2)
+	if err != nil {
+		yylex.(*lexerWrapper).reportError(err, $<pos>1, $<end>2)
+	}
+	$$ = &FuzzyNode{Term: $1, Fuzziness: fuzziness, Span: Span{Start: $<pos>1, End: $<end>2}}
 }
 |
 tSTRING tCOLON tSTRING tTILDE {
-    yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s FUZZY STRING - %s %s", $1, $3, $4)
-    q, err := queryStringStringTokenFuzzy(yylex, $1, $3, $4)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-	$$ = q
+	yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s FUZZY STRING - %s %s", $1, $3, $4)
+	fuzziness, err := queryStringParseFuzziness($4)
+	if err != nil {
+		yylex.(*lexerWrapper).reportError(err, $<pos>1, $<end>4)
+	}
+	$$ = &FuzzyNode{Field: $1, Term: $3, Fuzziness: fuzziness, Span: Span{Start: $<pos>1, End: $<end>4}}
+	$<pos>$ = $<pos>3
 }
 |
 tNUMBER {
 	yylex.(*lexerWrapper).logDebugGrammarf("STRING - %s", $1)
-	q, err := queryStringNumberToken(yylex, "", $1)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-	$$ = q
+	$$ = &NumberNode{Value: $1, Span: Span{Start: $<pos>1, End: $<end>1}}
 }
 |
 tPHRASE {
 	yylex.(*lexerWrapper).logDebugGrammarf("PHRASE - %s", $1)
-	$$ = queryStringPhraseToken("", $1)
+	$$ = &PhraseNode{Phrase: $1, Span: Span{Start: $<pos>1, End: $<end>1}}
+}
+|
+tPHRASE tTILDE {
+	yylex.(*lexerWrapper).logDebugGrammarf("PHRASE - %s SLOP - %s", $1, $2)
+	slop, err := queryStringParseSlop($2)
+	if err != nil {
+		yylex.(*lexerWrapper).reportError(err, $<pos>1, $<end>2)
+	}
+	$$ = &PhraseNode{Phrase: $1, Slop: slop, Span: Span{Start: $<pos>1, End: $<end>2}}
 }
 |
 tSTRING tCOLON tSTRING {
 	yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s STRING - %s", $1, $3)
-	$$ = queryStringStringToken(yylex, $1, $3)
+	$$ = queryStringStringNode($1, $3, $<lit>3, Span{Start: $<pos>1, End: $<end>3})
+	$<pos>$ = $<pos>3
 }
 |
 tSTRING tCOLON posOrNegNumber {
 	yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s STRING - %s", $1, $3)
-	q, err := queryStringNumberToken(yylex, $1, $3)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-	$$ = q
+	$$ = &NumberNode{Field: $1, Value: $3, Span: Span{Start: $<pos>1, End: $<end>3}}
 }
 |
 tSTRING tCOLON tPHRASE {
 	yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s PHRASE - %s", $1, $3)
-	$$ = queryStringPhraseToken($1, $3)
+	$$ = &PhraseNode{Field: $1, Phrase: $3, Span: Span{Start: $<pos>1, End: $<end>3}}
 }
 |
-tSTRING tCOLON tGREATER posOrNegNumber {
-    yylex.(*lexerWrapper).logDebugGrammarf("FIELD - GREATER THAN %s", $4)
-	q, err := queryStringNumericRangeGreaterThanOrEqual($1, $4, false)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-	$$ = q
+tSTRING tCOLON tPHRASE tTILDE {
+	yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s PHRASE - %s SLOP - %s", $1, $3, $4)
+	slop, err := queryStringParseSlop($4)
+	if err != nil {
+		yylex.(*lexerWrapper).reportError(err, $<pos>1, $<end>4)
+	}
+	$$ = &PhraseNode{Field: $1, Phrase: $3, Slop: slop, Span: Span{Start: $<pos>1, End: $<end>4}}
 }
 |
-tSTRING tCOLON tGREATER tEQUAL posOrNegNumber {
-    yylex.(*lexerWrapper).logDebugGrammarf("FIELD - GREATER THAN OR EQUAL %s", $5)
-    q, err := queryStringNumericRangeGreaterThanOrEqual($1, $5, true)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-    $$ = q
+tSTRING tCOLON searchRange {
+	yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s", $1)
+	$3.Field = $1
+	$3.Start = $<pos>1
+	$$ = $3
+	$<pos>$ = $<pos>3
 }
 |
-tSTRING tCOLON tLESS posOrNegNumber {
-    yylex.(*lexerWrapper).logDebugGrammarf("FIELD - LESS THAN %s", $4)
-    q, err := queryStringNumericRangeLessThanOrEqual($1, $4, false)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-    $$ = q
+tSTRING tCOLON tLEFTPAREN searchParts tRIGHTPAREN searchMinShould {
+	yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s GROUP", $1)
+	$4.Span = Span{Start: $<pos>1, End: $<end>5}
+	$4.MinShould = $6
+	$$ = queryStringFieldGroup(yylex.(*lexerWrapper), $1, $4)
 }
 |
-tSTRING tCOLON tLESS tEQUAL posOrNegNumber {
-    yylex.(*lexerWrapper).logDebugGrammarf("FIELD - LESS THAN OR EQUAL %s", $5)
-    q, err := queryStringNumericRangeLessThanOrEqual($1, $5, true)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-    $$ = q
+searchRange {
+	$$ = $1
+};
+
+searchRange:
+tGREATER rangeBound {
+	yylex.(*lexerWrapper).logDebugGrammarf("GREATER THAN %s", $2.String())
+	$$ = &RangeNode{Min: $2, Span: Span{Start: $<pos>1, End: $<end>2}}
 }
 |
-tSTRING tCOLON tGREATER tPHRASE {
-    yylex.(*lexerWrapper).logDebugGrammarf("FIELD - GREATER THAN DATE %s", $4)
-	q, err := queryStringDateRangeGreaterThanOrEqual(yylex, $1, $4, false)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-	$$ = q
+tGREATER tEQUAL rangeBound {
+	yylex.(*lexerWrapper).logDebugGrammarf("GREATER THAN OR EQUAL %s", $3.String())
+	$$ = &RangeNode{Min: $3, MinInclusive: true, Span: Span{Start: $<pos>1, End: $<end>3}}
 }
 |
-tSTRING tCOLON tGREATER tEQUAL tPHRASE {
-    yylex.(*lexerWrapper).logDebugGrammarf("FIELD - GREATER THAN OR EQUAL DATE %s", $5)
-    q, err := queryStringDateRangeGreaterThanOrEqual(yylex, $1, $5, true)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-	$$ = q
+tLESS rangeBound {
+	yylex.(*lexerWrapper).logDebugGrammarf("LESS THAN %s", $2.String())
+	$$ = &RangeNode{Max: $2, Span: Span{Start: $<pos>1, End: $<end>2}}
 }
 |
-tSTRING tCOLON tLESS tPHRASE {
-    yylex.(*lexerWrapper).logDebugGrammarf("FIELD - LESS THAN DATE %s", $4)
-    q, err := queryStringDateRangeLessThanOrEqual(yylex, $1, $4, false)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-	$$ = q
+tLESS tEQUAL rangeBound {
+	yylex.(*lexerWrapper).logDebugGrammarf("LESS THAN OR EQUAL %s", $3.String())
+	$$ = &RangeNode{Max: $3, MaxInclusive: true, Span: Span{Start: $<pos>1, End: $<end>3}}
 }
 |
-tSTRING tCOLON tLESS tEQUAL tPHRASE {
-    yylex.(*lexerWrapper).logDebugGrammarf("FIELD - LESS THAN OR EQUAL DATE %s", $5)
-    q, err := queryStringDateRangeLessThanOrEqual(yylex, $1, $5, true)
-    if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
-    }
-	$$ = q
+rangeStart rangeBound tTO rangeBound rangeEnd {
+	yylex.(*lexerWrapper).logDebugGrammarf("RANGE %s TO %s", $2.String(), $4.String())
+	$$ = &RangeNode{Min: $2, Max: $4, MinInclusive: $1, MaxInclusive: $5,
+		Span: Span{Start: $<pos>1, End: $<end>5}}
+};
+
+rangeStart:
+tLEFTBRACKET {
+	$$ = true
+}
+|
+tLEFTBRACE {
+	$$ = false
+};
+
+rangeEnd:
+tRIGHTBRACKET {
+	$$ = true
+}
+|
+tRIGHTBRACE {
+	$$ = false
+};
+
+rangeBound:
+posOrNegNumber {
+	$$ = &RangeBound{Kind: NumberValue, Value: $1}
+}
+|
+tPHRASE {
+	$$ = &RangeBound{Kind: PhraseValue, Value: $1}
+}
+|
+tSTRING {
+	// a bare * leaves the range open, an escaped one does not
+	$$ = nil
+	if $1 != "*" || $<lit>1 {
+		$$ = &RangeBound{Kind: TermValue, Value: $1}
+	}
 };
 
 searchSuffix:
@@ -217,12 +293,26 @@ tBOOST {
     yylex.(*lexerWrapper).logDebugGrammarf("BOOST %s", $1)
     boost, err := queryStringParseBoost($1)
     if err != nil {
-      yylex.(*lexerWrapper).lex.Error(err.Error())
+      yylex.(*lexerWrapper).reportError(err, $<pos>1, $<end>1)
     } else {
         $$ = &boost
     }
 };
 
+searchMinShould:
+/* empty */ {
+	$$ = yylex.(*lexerWrapper).opt.minShould
+}
+|
+tMINSHOULD {
+	yylex.(*lexerWrapper).logDebugGrammarf("MINSHOULD %s", $1)
+	$$ = $1
+	if _, err := queryStringMinShould($1, 0); err != nil {
+		yylex.(*lexerWrapper).reportError(err, $<pos>1, $<end>1)
+		$$ = ""
+	}
+};
+
 posOrNegNumber:
 tNUMBER {
 	$$ = $1
@@ -230,4 +320,5 @@ tNUMBER {
 |
 tMINUS tNUMBER {
 	$$ = "-" + $2
+	$<end>$ = $<end>2
 };
@@ -5,18 +5,23 @@ import __yyfmt__ "fmt"
 
 //line query_string.y:2
 
-import (
-	"github.com/blugelabs/bluge"
-)
-
-//line query_string.y:9
+//line query_string.y:6
 type yySymType struct {
-	yys int
-	s   string
-	n   int
-	f   float64
-	q   bluge.Query
-	pf  *float64
+	yys  int
+	s    string
+	n    int
+	f    float64
+	node Node
+	bn   *BooleanNode
+	c    *queryStringClause
+	o    Occur
+	rb   *RangeBound
+	b    bool
+	pf   *float64
+	lit  bool
+	rn   *RangeNode
+	pos  int
+	end  int
 }
 
 const tSTRING = 57346
@@ -30,6 +35,17 @@ const tGREATER = 57353
 const tLESS = 57354
 const tEQUAL = 57355
 const tTILDE = 57356
+const tLEFTPAREN = 57357
+const tRIGHTPAREN = 57358
+const tAND = 57359
+const tOR = 57360
+const tNOT = 57361
+const tLEFTBRACKET = 57362
+const tRIGHTBRACKET = 57363
+const tLEFTBRACE = 57364
+const tRIGHTBRACE = 57365
+const tTO = 57366
+const tMINSHOULD = 57367
 
 var yyToknames = [...]string{
 	"$end",
@@ -46,6 +62,17 @@ var yyToknames = [...]string{
 	"tLESS",
 	"tEQUAL",
 	"tTILDE",
+	"tLEFTPAREN",
+	"tRIGHTPAREN",
+	"tAND",
+	"tOR",
+	"tNOT",
+	"tLEFTBRACKET",
+	"tRIGHTBRACKET",
+	"tLEFTBRACE",
+	"tRIGHTBRACE",
+	"tTO",
+	"tMINSHOULD",
 }
 
 var yyStatenames = [...]string{}
@@ -55,74 +82,182 @@ const yyErrCode = 2
 const yyInitialStackSize = 16
 
 //line yacctab:1
-var yyExca = [...]int{
+var yyExca = [...]int8{
+	-1, 0,
+	4, 12,
+	5, 12,
+	10, 12,
+	11, 12,
+	12, 12,
+	15, 12,
+	20, 12,
+	22, 12,
+	-2, 0,
 	-1, 1,
 	1, -1,
 	-2, 0,
-	-1, 3,
-	1, 3,
-	-2, 5,
+	-1, 2,
+	1, 1,
+	4, 12,
+	5, 12,
+	10, 12,
+	11, 12,
+	12, 12,
+	15, 12,
+	20, 12,
+	22, 12,
+	-2, 0,
+	-1, 6,
+	4, 12,
+	5, 12,
+	10, 12,
+	11, 12,
+	12, 12,
+	15, 12,
+	20, 12,
+	22, 12,
+	-2, 0,
+	-1, 13,
+	4, 12,
+	5, 12,
+	10, 12,
+	11, 12,
+	12, 12,
+	15, 12,
+	20, 12,
+	22, 12,
+	-2, 0,
+	-1, 14,
+	4, 12,
+	5, 12,
+	10, 12,
+	11, 12,
+	12, 12,
+	15, 12,
+	20, 12,
+	22, 12,
+	-2, 0,
+	-1, 17,
+	4, 12,
+	5, 12,
+	10, 12,
+	11, 12,
+	12, 12,
+	15, 12,
+	20, 12,
+	22, 12,
+	-2, 0,
+	-1, 31,
+	4, 12,
+	5, 12,
+	10, 12,
+	11, 12,
+	12, 12,
+	15, 12,
+	20, 12,
+	22, 12,
+	-2, 0,
+	-1, 50,
+	4, 12,
+	5, 12,
+	10, 12,
+	11, 12,
+	12, 12,
+	15, 12,
+	20, 12,
+	22, 12,
+	-2, 0,
+	-1, 59,
+	4, 12,
+	5, 12,
+	10, 12,
+	11, 12,
+	12, 12,
+	15, 12,
+	20, 12,
+	22, 12,
+	-2, 0,
 }
 
 const yyPrivate = 57344
 
-const yyLast = 42
-
-var yyAct = [...]int{
-	17, 16, 18, 23, 22, 30, 3, 21, 19, 20,
-	29, 26, 22, 22, 1, 21, 21, 15, 28, 25,
-	24, 27, 34, 14, 22, 13, 31, 21, 32, 33,
-	22, 9, 11, 21, 5, 6, 2, 10, 4, 12,
-	7, 8,
+const yyLast = 100
+
+var yyAct = [...]int8{
+	3, 55, 35, 12, 2, 56, 21, 37, 63, 54,
+	64, 13, 46, 48, 14, 41, 58, 57, 40, 22,
+	23, 34, 31, 50, 4, 33, 42, 44, 25, 52,
+	26, 32, 12, 30, 1, 8, 18, 20, 27, 51,
+	49, 47, 19, 22, 23, 9, 53, 17, 29, 10,
+	11, 62, 25, 24, 26, 59, 7, 60, 16, 61,
+	12, 9, 6, 65, 9, 10, 11, 0, 10, 11,
+	39, 38, 0, 41, 0, 45, 40, 5, 6, 43,
+	0, 6, 39, 38, 15, 41, 0, 0, 40, 0,
+	0, 36, 28, 39, 38, 0, 41, 0, 0, 40,
 }
 
-var yyPact = [...]int{
-	28, -1000, -1000, 28, 27, -1000, -1000, -1000, 16, 9,
-	-1000, -1000, -1000, -1000, -1000, -3, -11, -1000, -1000, 6,
-	5, -1000, -5, -1000, -1000, 23, -1000, -1000, 17, -1000,
-	-1000, -1000, -1000, -1000, -1000,
+var yyPact = [...]int16{
+	62, -1000, 62, -7, -3, -1000, 62, -1000, 32, -1000,
+	-1000, -1000, -7, 62, 62, -1000, 24, 62, 17, -1000,
+	7, -1000, 78, 66, 89, -1000, -1000, -3, -1000, -1000,
+	-1000, 59, -1000, 8, -1000, -1000, 89, -1000, -1000, -1000,
+	-1000, 19, -1000, 89, -15, -20, 3, -1000, 2, -1000,
+	62, -1000, -1000, -1000, 89, -1000, -1000, -1000, -1000, 43,
+	-13, -20, -1000, -1000, -1000, -1000,
 }
 
-var yyPgo = [...]int{
-	0, 0, 41, 39, 38, 14, 36, 6,
+var yyPgo = [...]int8{
+	0, 7, 1, 58, 4, 0, 24, 77, 56, 6,
+	2, 53, 51, 48, 35, 34,
 }
 
-var yyR1 = [...]int{
-	0, 5, 6, 6, 7, 4, 4, 4, 2, 2,
-	2, 2, 2, 2, 2, 2, 2, 2, 2, 2,
-	2, 2, 2, 2, 3, 3, 1, 1,
+var yyR1 = [...]int8{
+	0, 15, 4, 4, 5, 5, 6, 6, 7, 7,
+	8, 8, 14, 14, 14, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 9,
+	9, 9, 9, 9, 11, 11, 12, 12, 10, 10,
+	10, 13, 13, 2, 2, 1, 1,
 }
 
-var yyR2 = [...]int{
-	0, 1, 2, 1, 3, 0, 1, 1, 1, 2,
-	4, 1, 1, 3, 3, 3, 4, 5, 4, 5,
-	4, 5, 4, 5, 0, 1, 1, 2,
+var yyR2 = [...]int8{
+	0, 1, 2, 1, 3, 1, 3, 1, 2, 1,
+	3, 1, 0, 1, 1, 4, 1, 2, 4, 1,
+	1, 2, 3, 3, 3, 4, 3, 6, 1, 2,
+	3, 2, 3, 5, 1, 1, 1, 1, 1, 1,
+	1, 0, 1, 0, 1, 1, 2,
 }
 
-var yyChk = [...]int{
-	-1000, -5, -6, -7, -4, 6, 7, -6, -2, 4,
-	10, 5, -3, 9, 14, 8, 4, -1, 5, 11,
-	12, 10, 7, 14, -1, 13, 5, -1, 13, 5,
-	10, -1, 5, -1, 5,
+var yyChk = [...]int16{
+	-1000, -15, -4, -5, -6, -7, 19, -8, -14, 2,
+	6, 7, -5, 18, 17, -7, -3, 15, 4, 10,
+	5, -9, 11, 12, -11, 20, 22, -6, -7, -13,
+	9, -4, 14, 8, 14, -10, 13, -1, 5, 4,
+	10, 7, -10, 13, -10, 16, 4, -1, 5, -9,
+	15, -10, 10, -10, 24, -2, 25, 14, 14, -4,
+	-10, 16, -12, 21, 23, -2,
 }
 
-var yyDef = [...]int{
-	5, -2, 1, -2, 0, 6, 7, 2, 24, 8,
-	11, 12, 4, 25, 9, 0, 13, 14, 15, 0,
-	0, 26, 0, 10, 16, 0, 20, 18, 0, 22,
-	27, 17, 21, 19, 23,
+var yyDef = [...]int8{
+	-2, -2, -2, 3, 5, 7, -2, 9, 0, 11,
+	13, 14, 2, -2, -2, 8, 41, -2, 16, 19,
+	20, 28, 0, 0, 0, 34, 35, 4, 6, 10,
+	42, -2, 17, 0, 21, 29, 0, 38, 39, 40,
+	45, 0, 31, 0, 0, 43, 22, 23, 24, 26,
+	-2, 30, 46, 32, 0, 15, 44, 18, 25, -2,
+	0, 43, 33, 36, 37, 27,
 }
 
-var yyTok1 = [...]int{
+var yyTok1 = [...]int8{
 	1,
 }
 
-var yyTok2 = [...]int{
+var yyTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
-	12, 13, 14,
+	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
+	22, 23, 24, 25,
 }
 
-var yyTok3 = [...]int{
+var yyTok3 = [...]int8{
 	0,
 }
 
@@ -201,12 +336,12 @@ func yyErrorMessage(state, lookAhead int) string {
 	res := "syntax error: unexpected " + yyTokname(lookAhead)
 
 	// To match Bison, suggest at most four expected tokens.
-	expected := make([]int, 0, 4)
+	expected := make([]int, 0, 16)
 
 	// Look for shiftable tokens.
-	base := yyPact[state]
+	base := int(yyPact[state])
 	for tok := TOKSTART; tok-1 < len(yyToknames); tok++ {
-		if n := base + tok; n >= 0 && n < yyLast && yyChk[yyAct[n]] == tok {
+		if n := base + tok; n >= 0 && n < yyLast && int(yyChk[int(yyAct[n])]) == tok {
 			if len(expected) == cap(expected) {
 				return res
 			}
@@ -216,13 +351,13 @@ func yyErrorMessage(state, lookAhead int) string {
 
 	if yyDef[state] == -2 {
 		i := 0
-		for yyExca[i] != -1 || yyExca[i+1] != state {
+		for yyExca[i] != -1 || int(yyExca[i+1]) != state {
 			i += 2
 		}
 
 		// Look for tokens that we accept or reduce.
 		for i += 2; yyExca[i] >= 0; i += 2 {
-			tok := yyExca[i]
+			tok := int(yyExca[i])
 			if tok < TOKSTART || yyExca[i+1] == 0 {
 				continue
 			}
@@ -253,30 +388,30 @@ func yylex1(lex yyLexer, lval *yySymType) (char, token int) {
 	token = 0
 	char = lex.Lex(lval)
 	if char <= 0 {
-		token = yyTok1[0]
+		token = int(yyTok1[0])
 		goto out
 	}
 	if char < len(yyTok1) {
-		token = yyTok1[char]
+		token = int(yyTok1[char])
 		goto out
 	}
 	if char >= yyPrivate {
 		if char < yyPrivate+len(yyTok2) {
-			token = yyTok2[char-yyPrivate]
+			token = int(yyTok2[char-yyPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(yyTok3); i += 2 {
-		token = yyTok3[i+0]
+		token = int(yyTok3[i+0])
 		if token == char {
-			token = yyTok3[i+1]
+			token = int(yyTok3[i+1])
 			goto out
 		}
 	}
 
 out:
 	if token == 0 {
-		token = yyTok2[1] /* unknown char */
+		token = int(yyTok2[1]) /* unknown char */
 	}
 	if yyDebug >= 3 {
 		__yyfmt__.Printf("lex %s(%d)\n", yyTokname(token), uint(char))
@@ -331,7 +466,7 @@ yystack:
 	yyS[yyp].yys = yystate
 
 yynewstate:
-	yyn = yyPact[yystate]
+	yyn = int(yyPact[yystate])
 	if yyn <= yyFlag {
 		goto yydefault /* simple state */
 	}
@@ -342,8 +477,8 @@ yynewstate:
 	if yyn < 0 || yyn >= yyLast {
 		goto yydefault
 	}
-	yyn = yyAct[yyn]
-	if yyChk[yyn] == yytoken { /* valid shift */
+	yyn = int(yyAct[yyn])
+	if int(yyChk[yyn]) == yytoken { /* valid shift */
 		yyrcvr.char = -1
 		yytoken = -1
 		yyVAL = yyrcvr.lval
@@ -356,7 +491,7 @@ yynewstate:
 
 yydefault:
 	/* default state action */
-	yyn = yyDef[yystate]
+	yyn = int(yyDef[yystate])
 	if yyn == -2 {
 		if yyrcvr.char < 0 {
 			yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
@@ -365,18 +500,18 @@ yydefault:
 		/* look through exception table */
 		xi := 0
 		for {
-			if yyExca[xi+0] == -1 && yyExca[xi+1] == yystate {
+			if yyExca[xi+0] == -1 && int(yyExca[xi+1]) == yystate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			yyn = yyExca[xi+0]
+			yyn = int(yyExca[xi+0])
 			if yyn < 0 || yyn == yytoken {
 				break
 			}
 		}
-		yyn = yyExca[xi+1]
+		yyn = int(yyExca[xi+1])
 		if yyn < 0 {
 			goto ret0
 		}
@@ -398,10 +533,10 @@ yydefault:
 
 			/* find a state where "error" is a legal shift action */
 			for yyp >= 0 {
-				yyn = yyPact[yyS[yyp].yys] + yyErrCode
+				yyn = int(yyPact[yyS[yyp].yys]) + yyErrCode
 				if yyn >= 0 && yyn < yyLast {
-					yystate = yyAct[yyn] /* simulate a shift of "error" */
-					if yyChk[yystate] == yyErrCode {
+					yystate = int(yyAct[yyn]) /* simulate a shift of "error" */
+					if int(yyChk[yystate]) == yyErrCode {
 						goto yystack
 					}
 				}
@@ -437,7 +572,7 @@ yydefault:
 	yypt := yyp
 	_ = yypt // guard against "declared and not used"
 
-	yyp -= yyR2[yyn]
+	yyp -= int(yyR2[yyn])
 	// yyp is now the index of $0. Perform the default action. Iff the
 	// reduced production is ε, $1 is possibly out of range.
 	if yyp+1 >= len(yyS) {
@@ -448,16 +583,16 @@ yydefault:
 	yyVAL = yyS[yyp+1]
 
 	/* consult goto table to find next state */
-	yyn = yyR1[yyn]
-	yyg := yyPgo[yyn]
+	yyn = int(yyR1[yyn])
+	yyg := int(yyPgo[yyn])
 	yyj := yyg + yyS[yyp].yys + 1
 
 	if yyj >= yyLast {
-		yystate = yyAct[yyg]
+		yystate = int(yyAct[yyg])
 	} else {
-		yystate = yyAct[yyj]
-		if yyChk[yystate] != -yyn {
-			yystate = yyAct[yyg]
+		yystate = int(yyAct[yyj])
+		if int(yyChk[yystate]) != -yyn {
+			yystate = int(yyAct[yyg])
 		}
 	}
 	// dummy call; replaced with literal code
@@ -465,253 +600,356 @@ yydefault:
 
 	case 1:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line query_string.y:32
+//line query_string.y:50
 		{
 			yylex.(*lexerWrapper).logDebugGrammarf("INPUT")
+			yyDollar[1].bn.MinShould = yylex.(*lexerWrapper).opt.minShould
+			yylex.(*lexerWrapper).checkRanges(yyDollar[1].bn)
+			yylex.(*lexerWrapper).checkLimits(yyDollar[1].bn)
+			yylex.(*lexerWrapper).root = yyDollar[1].bn
 		}
 	case 2:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line query_string.y:37
+//line query_string.y:59
 		{
 			yylex.(*lexerWrapper).logDebugGrammarf("SEARCH PARTS")
+			yyVAL.bn = queryStringAddClause(yyDollar[1].bn, yyDollar[2].c)
 		}
 	case 3:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line query_string.y:41
+//line query_string.y:64
 		{
 			yylex.(*lexerWrapper).logDebugGrammarf("SEARCH PART")
+			yyVAL.bn = queryStringAddClause(&BooleanNode{}, yyDollar[1].c)
 		}
 	case 4:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line query_string.y:46
-		{
-			q := yyDollar[2].q
-			if yyDollar[3].pf != nil {
-				var err error
-				q, err = queryStringSetBoost(yyDollar[2].q, *yyDollar[3].pf)
-				if err != nil {
-					yylex.(*lexerWrapper).lex.Error(err.Error())
-				}
-			}
-			switch yyDollar[1].n {
-			case queryShould:
-				yylex.(*lexerWrapper).query.AddShould(q)
-			case queryMust:
-				yylex.(*lexerWrapper).query.AddMust(q)
-			case queryMustNot:
-				yylex.(*lexerWrapper).query.AddMustNot(q)
-			}
+//line query_string.y:70
+		{
+			yylex.(*lexerWrapper).logDebugGrammarf("OR")
+			yyVAL.c = queryStringCombineClauses(queryOr, yylex.(*lexerWrapper).defaultOccur(), yyDollar[1].c, yyDollar[3].c)
+			yyVAL.c.Node.(*BooleanNode).MinShould = yylex.(*lexerWrapper).opt.minShould
 		}
 	case 5:
-		yyDollar = yyS[yypt-0 : yypt+1]
-//line query_string.y:67
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:76
 		{
-			yyVAL.n = queryShould
+			yyVAL.c = yyDollar[1].c
 		}
 	case 6:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line query_string.y:71
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line query_string.y:81
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("PLUS")
-			yyVAL.n = queryMust
+			yylex.(*lexerWrapper).logDebugGrammarf("AND")
+			yyVAL.c = queryStringCombineClauses(queryAnd, yylex.(*lexerWrapper).defaultOccur(), yyDollar[1].c, yyDollar[3].c)
 		}
 	case 7:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line query_string.y:76
+//line query_string.y:86
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("MINUS")
-			yyVAL.n = queryMustNot
+			yyVAL.c = yyDollar[1].c
 		}
 	case 8:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line query_string.y:82
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line query_string.y:91
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("STRING - %s", yyDollar[1].s)
-			yyVAL.q = queryStringStringToken(yylex, "", yyDollar[1].s)
+			yylex.(*lexerWrapper).logDebugGrammarf("NOT")
+			yyVAL.c = queryStringNegateClause(yyDollar[2].c)
 		}
 	case 9:
-		yyDollar = yyS[yypt-2 : yypt+1]
-//line query_string.y:87
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:96
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FUZZY STRING - %s %s", yyDollar[1].s, yyDollar[2].s)
-			q, err := queryStringStringTokenFuzzy(yylex, "", yyDollar[1].s, yyDollar[2].s)
-			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
-			}
-			yyVAL.q = q
+			yyVAL.c = yyDollar[1].c
 		}
 	case 10:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line query_string.y:96
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line query_string.y:101
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s FUZZY STRING - %s %s", yyDollar[1].s, yyDollar[3].s, yyDollar[4].s)
-			q, err := queryStringStringTokenFuzzy(yylex, yyDollar[1].s, yyDollar[3].s, yyDollar[4].s)
-			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
-			}
-			yyVAL.q = q
+			yylex.(*lexerWrapper).checkFeatures(yyDollar[2].node, yyDollar[2].pos)
+			boost := yylex.(*lexerWrapper).checkBoost(yyDollar[2].node, yyDollar[3].pf, yyDollar[3].pos, yyDollar[3].end)
+			yyVAL.c = &queryStringClause{Clause: &Clause{Occur: yyDollar[1].o, Node: yyDollar[2].node, Boost: boost}}
 		}
 	case 11:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line query_string.y:105
+//line query_string.y:107
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("STRING - %s", yyDollar[1].s)
-			q, err := queryStringNumberToken(yylex, "", yyDollar[1].s)
-			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
-			}
-			yyVAL.q = q
+			// parsing carries on after a syntax error, to find any others
+			// in the same pass when lenient, the tree is not used
+			yyVAL.c = &queryStringClause{Clause: &Clause{Occur: OccurShould, Node: &BooleanNode{}}}
 		}
 	case 12:
-		yyDollar = yyS[yypt-1 : yypt+1]
-//line query_string.y:114
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line query_string.y:115
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("PHRASE - %s", yyDollar[1].s)
-			yyVAL.q = queryStringPhraseToken("", yyDollar[1].s)
+			yyVAL.o = yylex.(*lexerWrapper).defaultOccur()
 		}
 	case 13:
-		yyDollar = yyS[yypt-3 : yypt+1]
+		yyDollar = yyS[yypt-1 : yypt+1]
 //line query_string.y:119
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s STRING - %s", yyDollar[1].s, yyDollar[3].s)
-			yyVAL.q = queryStringStringToken(yylex, yyDollar[1].s, yyDollar[3].s)
+			yylex.(*lexerWrapper).logDebugGrammarf("PLUS")
+			yyVAL.o = OccurMust
 		}
 	case 14:
-		yyDollar = yyS[yypt-3 : yypt+1]
+		yyDollar = yyS[yypt-1 : yypt+1]
 //line query_string.y:124
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s STRING - %s", yyDollar[1].s, yyDollar[3].s)
-			q, err := queryStringNumberToken(yylex, yyDollar[1].s, yyDollar[3].s)
-			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
-			}
-			yyVAL.q = q
+			yylex.(*lexerWrapper).logDebugGrammarf("MINUS")
+			yyVAL.o = OccurMustNot
 		}
 	case 15:
-		yyDollar = yyS[yypt-3 : yypt+1]
-//line query_string.y:133
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line query_string.y:130
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s PHRASE - %s", yyDollar[1].s, yyDollar[3].s)
-			yyVAL.q = queryStringPhraseToken(yyDollar[1].s, yyDollar[3].s)
+			yylex.(*lexerWrapper).logDebugGrammarf("GROUP")
+			yyDollar[2].bn.Span = Span{Start: yyDollar[1].pos, End: yyDollar[3].end}
+			yyDollar[2].bn.MinShould = yyDollar[4].s
+			yyVAL.node = yyDollar[2].bn
 		}
 	case 16:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line query_string.y:138
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:137
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - GREATER THAN %s", yyDollar[4].s)
-			q, err := queryStringNumericRangeGreaterThanOrEqual(yyDollar[1].s, yyDollar[4].s, false)
-			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
-			}
-			yyVAL.q = q
+			yylex.(*lexerWrapper).logDebugGrammarf("STRING - %s", yyDollar[1].s)
+			yyVAL.node = queryStringStringNode("", yyDollar[1].s, yyDollar[1].lit, Span{Start: yyDollar[1].pos, End: yyDollar[1].end})
 		}
 	case 17:
-		yyDollar = yyS[yypt-5 : yypt+1]
-//line query_string.y:147
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line query_string.y:142
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - GREATER THAN OR EQUAL %s", yyDollar[5].s)
-			q, err := queryStringNumericRangeGreaterThanOrEqual(yyDollar[1].s, yyDollar[5].s, true)
+			yylex.(*lexerWrapper).logDebugGrammarf("FUZZY STRING - %s %s", yyDollar[1].s, yyDollar[2].s)
+			fuzziness, err := queryStringParseFuzziness(yyDollar[2].s)
 			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
+				yylex.(*lexerWrapper).reportError(err, yyDollar[1].pos, yyDollar[2].end)
 			}
-			yyVAL.q = q
+			yyVAL.node = &FuzzyNode{Term: yyDollar[1].s, Fuzziness: fuzziness, Span: Span{Start: yyDollar[1].pos, End: yyDollar[2].end}}
 		}
 	case 18:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line query_string.y:156
+//line query_string.y:151
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - LESS THAN %s", yyDollar[4].s)
-			q, err := queryStringNumericRangeLessThanOrEqual(yyDollar[1].s, yyDollar[4].s, false)
+			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s FUZZY STRING - %s %s", yyDollar[1].s, yyDollar[3].s, yyDollar[4].s)
+			fuzziness, err := queryStringParseFuzziness(yyDollar[4].s)
 			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
+				yylex.(*lexerWrapper).reportError(err, yyDollar[1].pos, yyDollar[4].end)
 			}
-			yyVAL.q = q
+			yyVAL.node = &FuzzyNode{Field: yyDollar[1].s, Term: yyDollar[3].s, Fuzziness: fuzziness, Span: Span{Start: yyDollar[1].pos, End: yyDollar[4].end}}
+			yyVAL.pos = yyDollar[3].pos
 		}
 	case 19:
-		yyDollar = yyS[yypt-5 : yypt+1]
-//line query_string.y:165
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:161
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - LESS THAN OR EQUAL %s", yyDollar[5].s)
-			q, err := queryStringNumericRangeLessThanOrEqual(yyDollar[1].s, yyDollar[5].s, true)
-			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
-			}
-			yyVAL.q = q
+			yylex.(*lexerWrapper).logDebugGrammarf("STRING - %s", yyDollar[1].s)
+			yyVAL.node = &NumberNode{Value: yyDollar[1].s, Span: Span{Start: yyDollar[1].pos, End: yyDollar[1].end}}
 		}
 	case 20:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line query_string.y:174
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:166
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - GREATER THAN DATE %s", yyDollar[4].s)
-			q, err := queryStringDateRangeGreaterThanOrEqual(yylex, yyDollar[1].s, yyDollar[4].s, false)
-			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
-			}
-			yyVAL.q = q
+			yylex.(*lexerWrapper).logDebugGrammarf("PHRASE - %s", yyDollar[1].s)
+			yyVAL.node = &PhraseNode{Phrase: yyDollar[1].s, Span: Span{Start: yyDollar[1].pos, End: yyDollar[1].end}}
 		}
 	case 21:
-		yyDollar = yyS[yypt-5 : yypt+1]
-//line query_string.y:183
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line query_string.y:171
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - GREATER THAN OR EQUAL DATE %s", yyDollar[5].s)
-			q, err := queryStringDateRangeGreaterThanOrEqual(yylex, yyDollar[1].s, yyDollar[5].s, true)
+			yylex.(*lexerWrapper).logDebugGrammarf("PHRASE - %s SLOP - %s", yyDollar[1].s, yyDollar[2].s)
+			slop, err := queryStringParseSlop(yyDollar[2].s)
 			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
+				yylex.(*lexerWrapper).reportError(err, yyDollar[1].pos, yyDollar[2].end)
 			}
-			yyVAL.q = q
+			yyVAL.node = &PhraseNode{Phrase: yyDollar[1].s, Slop: slop, Span: Span{Start: yyDollar[1].pos, End: yyDollar[2].end}}
 		}
 	case 22:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line query_string.y:180
+		{
+			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s STRING - %s", yyDollar[1].s, yyDollar[3].s)
+			yyVAL.node = queryStringStringNode(yyDollar[1].s, yyDollar[3].s, yyDollar[3].lit, Span{Start: yyDollar[1].pos, End: yyDollar[3].end})
+			yyVAL.pos = yyDollar[3].pos
+		}
+	case 23:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line query_string.y:186
+		{
+			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s STRING - %s", yyDollar[1].s, yyDollar[3].s)
+			yyVAL.node = &NumberNode{Field: yyDollar[1].s, Value: yyDollar[3].s, Span: Span{Start: yyDollar[1].pos, End: yyDollar[3].end}}
+		}
+	case 24:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line query_string.y:191
+		{
+			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s PHRASE - %s", yyDollar[1].s, yyDollar[3].s)
+			yyVAL.node = &PhraseNode{Field: yyDollar[1].s, Phrase: yyDollar[3].s, Span: Span{Start: yyDollar[1].pos, End: yyDollar[3].end}}
+		}
+	case 25:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line query_string.y:192
+//line query_string.y:196
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - LESS THAN DATE %s", yyDollar[4].s)
-			q, err := queryStringDateRangeLessThanOrEqual(yylex, yyDollar[1].s, yyDollar[4].s, false)
+			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s PHRASE - %s SLOP - %s", yyDollar[1].s, yyDollar[3].s, yyDollar[4].s)
+			slop, err := queryStringParseSlop(yyDollar[4].s)
 			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
+				yylex.(*lexerWrapper).reportError(err, yyDollar[1].pos, yyDollar[4].end)
 			}
-			yyVAL.q = q
+			yyVAL.node = &PhraseNode{Field: yyDollar[1].s, Phrase: yyDollar[3].s, Slop: slop, Span: Span{Start: yyDollar[1].pos, End: yyDollar[4].end}}
 		}
-	case 23:
+	case 26:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line query_string.y:205
+		{
+			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s", yyDollar[1].s)
+			yyDollar[3].rn.Field = yyDollar[1].s
+			yyDollar[3].rn.Start = yyDollar[1].pos
+			yyVAL.node = yyDollar[3].rn
+			yyVAL.pos = yyDollar[3].pos
+		}
+	case 27:
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line query_string.y:213
+		{
+			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - %s GROUP", yyDollar[1].s)
+			yyDollar[4].bn.Span = Span{Start: yyDollar[1].pos, End: yyDollar[5].end}
+			yyDollar[4].bn.MinShould = yyDollar[6].s
+			yyVAL.node = queryStringFieldGroup(yylex.(*lexerWrapper), yyDollar[1].s, yyDollar[4].bn)
+		}
+	case 28:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:220
+		{
+			yyVAL.node = yyDollar[1].rn
+		}
+	case 29:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line query_string.y:225
+		{
+			yylex.(*lexerWrapper).logDebugGrammarf("GREATER THAN %s", yyDollar[2].rb.String())
+			yyVAL.rn = &RangeNode{Min: yyDollar[2].rb, Span: Span{Start: yyDollar[1].pos, End: yyDollar[2].end}}
+		}
+	case 30:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line query_string.y:230
+		{
+			yylex.(*lexerWrapper).logDebugGrammarf("GREATER THAN OR EQUAL %s", yyDollar[3].rb.String())
+			yyVAL.rn = &RangeNode{Min: yyDollar[3].rb, MinInclusive: true, Span: Span{Start: yyDollar[1].pos, End: yyDollar[3].end}}
+		}
+	case 31:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line query_string.y:235
+		{
+			yylex.(*lexerWrapper).logDebugGrammarf("LESS THAN %s", yyDollar[2].rb.String())
+			yyVAL.rn = &RangeNode{Max: yyDollar[2].rb, Span: Span{Start: yyDollar[1].pos, End: yyDollar[2].end}}
+		}
+	case 32:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line query_string.y:240
+		{
+			yylex.(*lexerWrapper).logDebugGrammarf("LESS THAN OR EQUAL %s", yyDollar[3].rb.String())
+			yyVAL.rn = &RangeNode{Max: yyDollar[3].rb, MaxInclusive: true, Span: Span{Start: yyDollar[1].pos, End: yyDollar[3].end}}
+		}
+	case 33:
 		yyDollar = yyS[yypt-5 : yypt+1]
-//line query_string.y:201
+//line query_string.y:245
 		{
-			yylex.(*lexerWrapper).logDebugGrammarf("FIELD - LESS THAN OR EQUAL DATE %s", yyDollar[5].s)
-			q, err := queryStringDateRangeLessThanOrEqual(yylex, yyDollar[1].s, yyDollar[5].s, true)
-			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
+			yylex.(*lexerWrapper).logDebugGrammarf("RANGE %s TO %s", yyDollar[2].rb.String(), yyDollar[4].rb.String())
+			yyVAL.rn = &RangeNode{Min: yyDollar[2].rb, Max: yyDollar[4].rb, MinInclusive: yyDollar[1].b, MaxInclusive: yyDollar[5].b,
+				Span: Span{Start: yyDollar[1].pos, End: yyDollar[5].end}}
+		}
+	case 34:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:252
+		{
+			yyVAL.b = true
+		}
+	case 35:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:256
+		{
+			yyVAL.b = false
+		}
+	case 36:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:261
+		{
+			yyVAL.b = true
+		}
+	case 37:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:265
+		{
+			yyVAL.b = false
+		}
+	case 38:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:270
+		{
+			yyVAL.rb = &RangeBound{Kind: NumberValue, Value: yyDollar[1].s}
+		}
+	case 39:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:274
+		{
+			yyVAL.rb = &RangeBound{Kind: PhraseValue, Value: yyDollar[1].s}
+		}
+	case 40:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:278
+		{
+			// a bare * leaves the range open, an escaped one does not
+			yyVAL.rb = nil
+			if yyDollar[1].s != "*" || yyDollar[1].lit {
+				yyVAL.rb = &RangeBound{Kind: TermValue, Value: yyDollar[1].s}
 			}
-			yyVAL.q = q
 		}
-	case 24:
+	case 41:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line query_string.y:211
+//line query_string.y:287
 		{
 			yyVAL.pf = nil
 		}
-	case 25:
+	case 42:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line query_string.y:215
+//line query_string.y:291
 		{
 			yyVAL.pf = nil
 			yylex.(*lexerWrapper).logDebugGrammarf("BOOST %s", yyDollar[1].s)
 			boost, err := queryStringParseBoost(yyDollar[1].s)
 			if err != nil {
-				yylex.(*lexerWrapper).lex.Error(err.Error())
+				yylex.(*lexerWrapper).reportError(err, yyDollar[1].pos, yyDollar[1].end)
 			} else {
 				yyVAL.pf = &boost
 			}
 		}
-	case 26:
+	case 43:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line query_string.y:303
+		{
+			yyVAL.s = yylex.(*lexerWrapper).opt.minShould
+		}
+	case 44:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line query_string.y:227
+//line query_string.y:307
 		{
+			yylex.(*lexerWrapper).logDebugGrammarf("MINSHOULD %s", yyDollar[1].s)
 			yyVAL.s = yyDollar[1].s
+			if _, err := queryStringMinShould(yyDollar[1].s, 0); err != nil {
+				yylex.(*lexerWrapper).reportError(err, yyDollar[1].pos, yyDollar[1].end)
+				yyVAL.s = ""
+			}
 		}
-	case 27:
+	case 45:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line query_string.y:317
+		{
+			yyVAL.s = yyDollar[1].s
+		}
+	case 46:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line query_string.y:231
+//line query_string.y:321
 		{
 			yyVAL.s = "-" + yyDollar[2].s
+			yyVAL.end = yyDollar[2].end
 		}
 	}
 	goto yystack /* stack new state and value */
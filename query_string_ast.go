@@ -0,0 +1,157 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querystr
+
+// Span is the part of the query string a node was parsed from,
+// Start and End are byte offsets, End being exclusive
+type Span struct {
+	Start int
+	End   int
+}
+
+// Position returns the span, it allows any node
+// embedding a Span to satisfy Node
+func (s Span) Position() Span {
+	return s
+}
+
+// Node is part of the syntax tree of a query string, as returned
+// by ParseAST, the tree is turned into a bluge.Query by Compile
+type Node interface {
+	Position() Span
+}
+
+// Occur is how a clause occurs in the boolean query containing it
+type Occur int
+
+const (
+	// OccurShould clauses are optional, although unless there are
+	// also OccurMust clauses at least one of them has to match
+	OccurShould Occur = iota
+	// OccurMust clauses have to match, written with a + prefix or AND
+	OccurMust
+	// OccurMustNot clauses must not match, written with a - prefix or NOT
+	OccurMustNot
+)
+
+// Clause is one of the nodes combined by a BooleanNode,
+// Boost is nil unless the clause has a ^ suffix
+type Clause struct {
+	Occur Occur
+	Node  Node
+	Boost *float64
+}
+
+// BooleanNode combines clauses, one is built for the whole query string,
+// for each group in parentheses, and for each chain of the same operator.
+// MinShould is how many of the optional clauses have to match, as written
+// after a group with @, or taken from the options, empty for at least one.
+type BooleanNode struct {
+	Clauses   []*Clause
+	MinShould string
+	Span
+}
+
+// TermNode searches a field for a term, the term is analyzed,
+// unless the field is declared with a type other than text
+type TermNode struct {
+	Field string
+	Term  string
+	Span
+}
+
+// NumberNode searches a field for a number, fields not in the
+// schema are searched for both the text and the numeric value
+type NumberNode struct {
+	Field string
+	Value string
+	Span
+}
+
+// PhraseNode searches a field for a phrase, the terms of which
+// may be up to Slop positions from where they are in the phrase
+type PhraseNode struct {
+	Field  string
+	Phrase string
+	Slop   int
+	Span
+}
+
+// FuzzyNode searches a field for terms up to Fuzziness edits from Term
+type FuzzyNode struct {
+	Field     string
+	Term      string
+	Fuzziness int
+	Span
+}
+
+// WildcardNode searches a field for terms matching a pattern
+// of * and ? wildcards
+type WildcardNode struct {
+	Field   string
+	Pattern string
+	Span
+}
+
+// RegexpNode searches a field for terms matching a regular expression,
+// Pattern does not include the enclosing slashes
+type RegexpNode struct {
+	Field   string
+	Pattern string
+	Span
+}
+
+// RangeNode searches a field for values between Min and Max,
+// a nil bound leaves that end of the range open
+type RangeNode struct {
+	Field        string
+	Min          *RangeBound
+	Max          *RangeBound
+	MinInclusive bool
+	MaxInclusive bool
+	Span
+}
+
+// MatchAllNode matches every document, written * or *:*
+type MatchAllNode struct {
+	Span
+}
+
+// ExistsNode matches documents with a value in Field, written _exists_:field,
+// or when Missing those without one, written _missing_:field
+type ExistsNode struct {
+	Field   string
+	Missing bool
+	Span
+}
+
+// ValueKind is how a value was written in the query string
+type ValueKind int
+
+const (
+	// TermValue is a plain string
+	TermValue ValueKind = iota
+	// NumberValue is a number, possibly negative
+	NumberValue
+	// PhraseValue is a string in double quotes
+	PhraseValue
+)
+
+// RangeBound is one end of a range, the kind of value is used
+// to decide what sort of range to build for fields not in the schema
+type RangeBound struct {
+	Kind  ValueKind
+	Value string
+}
@@ -0,0 +1,343 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querystr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Builder puts together query string text clause by clause, values
+// are escaped so that they are always searched for as written. The
+// text is meant to be parsed with the OR default operator.
+type Builder struct {
+	root BooleanNode
+	err  error
+}
+
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Must adds clauses which have to match
+func (b *Builder) Must(exprs ...*Expr) *Builder {
+	return b.add(OccurMust, exprs)
+}
+
+// Should adds optional clauses
+func (b *Builder) Should(exprs ...*Expr) *Builder {
+	return b.add(OccurShould, exprs)
+}
+
+// MustNot adds clauses which must not match
+func (b *Builder) MustNot(exprs ...*Expr) *Builder {
+	return b.add(OccurMustNot, exprs)
+}
+
+// Range adds an optional clause for the inclusive range, see Range
+func (b *Builder) Range(field string, min, max interface{}) *Builder {
+	return b.Should(Range(field, min, max))
+}
+
+func (b *Builder) add(occur Occur, exprs []*Expr) *Builder {
+	for _, e := range exprs {
+		if e.err != nil {
+			if b.err == nil {
+				b.err = e.err
+			}
+			continue
+		}
+		b.root.Clauses = append(b.root.Clauses, &Clause{Occur: occur, Node: e.node, Boost: e.boost})
+	}
+	return b
+}
+
+// AST returns the syntax tree of the query built so far, for use with
+// Compile, clauses which could not be built are left out, see Build
+func (b *Builder) AST() *BooleanNode {
+	return &b.root
+}
+
+// Build returns the query string text, or the first problem found
+// with the clauses added, like a range without a field. The text is
+// parsed again to check it reads as the clauses that were added.
+func (b *Builder) Build() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	rv := b.root.String()
+	again, err := ParseAST(rv, DefaultOptions())
+	if err != nil {
+		return "", fmt.Errorf("cannot build `%s`: %v", rv, err)
+	}
+	if again.String() != rv || !sameKinds(&b.root, again) {
+		return "", fmt.Errorf("cannot build `%s`, it reads as something else", rv)
+	}
+	return rv, nil
+}
+
+// sameKinds reports whether the nodes are of the same kinds all the
+// way down, so text they both format as reads the same for either
+func sameKinds(a, b Node) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	ab, ok := a.(*BooleanNode)
+	if !ok {
+		return true
+	}
+	bb := b.(*BooleanNode)
+	if len(ab.Clauses) != len(bb.Clauses) {
+		return false
+	}
+	for i := range ab.Clauses {
+		if !sameKinds(ab.Clauses[i].Node, bb.Clauses[i].Node) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the query string text, clauses
+// which could not be built are left out, see Build
+func (b *Builder) String() string {
+	return b.root.String()
+}
+
+// Expr is a single clause for a Builder
+type Expr struct {
+	node  Node
+	boost *float64
+	err   error
+}
+
+// Boost sets how much more the clause counts towards the score
+func (e *Expr) Boost(boost float64) *Expr {
+	e.boost = &boost
+	return e
+}
+
+// Slop sets how far apart the terms of a phrase may be,
+// it has no effect on other kinds of clause
+func (e *Expr) Slop(slop int) *Expr {
+	if n, ok := e.node.(*PhraseNode); ok {
+		if slop < 0 {
+			e.err = fmt.Errorf("cannot build phrase with slop %d", slop)
+		}
+		n.Slop = slop
+	}
+	return e
+}
+
+// Inclusive sets whether each bound of a range is part of it,
+// it has no effect on other kinds of clause
+func (e *Expr) Inclusive(min, max bool) *Expr {
+	if n, ok := e.node.(*RangeNode); ok {
+		n.MinInclusive, n.MaxInclusive = min, max
+	}
+	return e
+}
+
+// Term searches the default field for a term
+func Term(term string) *Expr {
+	return Field("", term)
+}
+
+// Field searches the field for a term
+func Field(field, term string) *Expr {
+	if err := checkTerm(field, "term", term); err != nil {
+		return &Expr{err: err}
+	}
+	return &Expr{node: &TermNode{Field: field, Term: term}}
+}
+
+// Phrase searches the field for a phrase, the
+// empty field name searches the default field
+func Phrase(field, phrase string) *Expr {
+	return &Expr{node: &PhraseNode{Field: field, Phrase: phrase}}
+}
+
+// Number searches the field for a number, a negative number
+// in the default field can only be searched for as a term
+func Number(field string, value float64) *Expr {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return &Expr{err: fmt.Errorf("cannot build number %v", value)}
+	}
+	str := strconv.FormatFloat(value, 'f', -1, 64)
+	if field == "" && value < 0 {
+		return Term(str)
+	}
+	return &Expr{node: &NumberNode{Field: field, Value: str}}
+}
+
+// Fuzzy searches the field for terms up to fuzziness edits from the term
+func Fuzzy(field, term string, fuzziness int) *Expr {
+	if err := checkTerm(field, "fuzzy term", term); err != nil {
+		return &Expr{err: err}
+	}
+	return &Expr{node: &FuzzyNode{Field: field, Term: term, Fuzziness: fuzziness}}
+}
+
+// Wildcard searches the field for terms matching a pattern,
+// where * and ? are wildcards
+func Wildcard(field, pattern string) *Expr {
+	if err := checkTerm(field, "wildcard", pattern); err != nil {
+		return &Expr{err: err}
+	}
+	if !strings.ContainsAny(pattern, "*?") {
+		return &Expr{err: fmt.Errorf("cannot build wildcard %s without * or ?, use Field", pattern)}
+	}
+	if pattern == "*" && (field == "" || field == "*") {
+		return &Expr{err: fmt.Errorf("cannot build wildcard * without a field, use MatchAll")}
+	}
+	return &Expr{node: &WildcardNode{Field: field, Pattern: pattern}}
+}
+
+// Regexp searches the field for terms matching a regular expression
+func Regexp(field, pattern string) *Expr {
+	if err := checkTerm(field, "regexp", "/"+pattern+"/"); err != nil {
+		return &Expr{err: err}
+	}
+	return &Expr{node: &RegexpNode{Field: field, Pattern: pattern}}
+}
+
+// MatchAll matches every document
+func MatchAll() *Expr {
+	return &Expr{node: &MatchAllNode{}}
+}
+
+// Exists matches documents with a value in the field
+func Exists(field string) *Expr {
+	if field == "" {
+		return &Expr{err: fmt.Errorf("cannot build exists without a field")}
+	}
+	return &Expr{node: &ExistsNode{Field: field}}
+}
+
+// Missing matches documents without a value in the field
+func Missing(field string) *Expr {
+	if field == "" {
+		return &Expr{err: fmt.Errorf("cannot build missing without a field")}
+	}
+	return &Expr{node: &ExistsNode{Field: field, Missing: true}}
+}
+
+// Range searches the field for values from min to max inclusive.
+// Bounds may be numbers, strings or times, the latter written in
+// RFC3339, the default date format, a nil bound leaves the range
+// open, with both nil any value in the field matches.
+func Range(field string, min, max interface{}) *Expr {
+	if field == "" {
+		return &Expr{err: fmt.Errorf("cannot build range without a field")}
+	}
+	minBound, err := rangeBound(min)
+	if err != nil {
+		return &Expr{err: err}
+	}
+	maxBound, err := rangeBound(max)
+	if err != nil {
+		return &Expr{err: err}
+	}
+	return &Expr{node: &RangeNode{
+		Field:        field,
+		Min:          minBound,
+		Max:          maxBound,
+		MinInclusive: true,
+		MaxInclusive: true,
+	}}
+}
+
+// GreaterThan searches the field for values above min
+func GreaterThan(field string, min interface{}) *Expr {
+	return Range(field, min, nil).Inclusive(false, true)
+}
+
+// GreaterThanOrEqual searches the field for values from min
+func GreaterThanOrEqual(field string, min interface{}) *Expr {
+	return Range(field, min, nil)
+}
+
+// LessThan searches the field for values below max
+func LessThan(field string, max interface{}) *Expr {
+	return Range(field, nil, max).Inclusive(true, false)
+}
+
+// LessThanOrEqual searches the field for values up to max
+func LessThanOrEqual(field string, max interface{}) *Expr {
+	return Range(field, nil, max)
+}
+
+// MinShould sets how many of the optional clauses of a group have
+// to match, see WithMinShouldMatch, it has no effect on other kinds
+// of clause
+func (e *Expr) MinShould(spec string) *Expr {
+	if n, ok := e.node.(*BooleanNode); ok {
+		if _, err := queryStringMinShould(spec, len(n.Clauses)); spec != "" && err != nil {
+			e.err = err
+		}
+		n.MinShould = spec
+	}
+	return e
+}
+
+// Group nests the clauses of another builder in parentheses,
+// along with any problem found with them
+func Group(b *Builder) *Expr {
+	if b.err == nil && len(b.root.Clauses) == 0 {
+		return &Expr{err: fmt.Errorf("cannot build an empty group")}
+	}
+	return &Expr{node: &BooleanNode{Clauses: append([]*Clause(nil), b.root.Clauses...)}, err: b.err}
+}
+
+// checkTerm returns an error for a term which cannot be written, or
+// which would be read as something else, like an exists query
+func checkTerm(field, kind, term string) error {
+	if term == "" {
+		return fmt.Errorf("cannot build an empty %s", kind)
+	}
+	if field == "_exists_" || field == "_missing_" {
+		return fmt.Errorf("cannot build %s in %s, use Exists or Missing", kind, field)
+	}
+	return nil
+}
+
+func rangeBound(v interface{}) (*RangeBound, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return &RangeBound{Kind: TermValue, Value: v}, nil
+	case time.Time:
+		return &RangeBound{Kind: PhraseValue, Value: v.Format(time.RFC3339)}, nil
+	case float64:
+		return floatBound(v, 64)
+	case float32:
+		return floatBound(float64(v), 32)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return &RangeBound{Kind: NumberValue, Value: fmt.Sprint(v)}, nil
+	}
+	return &RangeBound{Kind: TermValue, Value: fmt.Sprint(v)}, nil
+}
+
+func floatBound(v float64, bitSize int) (*RangeBound, error) {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil, fmt.Errorf("cannot build range bound %v", v)
+	}
+	return &RangeBound{Kind: NumberValue, Value: strconv.FormatFloat(v, 'f', -1, bitSize)}, nil
+}
@@ -0,0 +1,304 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querystr
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/blugelabs/bluge"
+)
+
+// Compile builds the bluge.Query for a syntax tree, using the schema and
+// analyzers in the options, any problems found are returned as ParseErrors,
+// positioned by the Span of the node at fault
+func Compile(node Node, options QueryStringOptions) (bluge.Query, error) {
+	c := newCompiler(options)
+	rv := c.compileRoot(node)
+	if len(c.errs) > 0 {
+		return nil, ParseErrors(c.errs)
+	}
+	return rv, nil
+}
+
+type compiler struct {
+	opt     *QueryStringOptions
+	errs    []*ParseError
+	clauses int
+}
+
+func newCompiler(options QueryStringOptions) *compiler {
+	return &compiler{
+		opt: &options,
+	}
+}
+
+// compileRoot builds the query for the whole query string, which
+// when it only says what must not match, matches everything else
+func (c *compiler) compileRoot(node Node) bluge.Query {
+	rv := c.compile(node)
+	if bq, ok := rv.(*bluge.BooleanQuery); ok && onlyMustNots(bq) {
+		bq.AddMust(bluge.NewMatchAllQuery())
+	}
+	return rv
+}
+
+func onlyMustNots(q *bluge.BooleanQuery) bool {
+	return len(q.MustNots()) > 0 && len(q.Musts()) == 0 && len(q.Shoulds()) == 0
+}
+
+func (c *compiler) compile(node Node) bluge.Query {
+	if nilNode(node) {
+		c.reportError(parseErrorf(ErrorCodeUnsupported, "cannot compile a missing node"), node)
+		return nil
+	}
+	switch n := node.(type) {
+	case *BooleanNode:
+		return c.compileBoolean(n)
+	case *MatchAllNode:
+		return bluge.NewMatchAllQuery()
+	}
+	fields, err := c.nodeFields(node)
+	if err != nil {
+		c.reportError(err, node)
+		return nil
+	}
+	return c.compileFields(node, fields)
+}
+
+// nodeFields returns the fields to search for a node, as written,
+// the default fields for a node without one
+func (c *compiler) nodeFields(node Node) ([]boostedField, error) {
+	field := nodeField(node)
+	switch node.(type) {
+	case *RangeNode, *ExistsNode:
+		// the parser never builds these without a field
+		if field == "" {
+			return nil, parseErrorf(ErrorCodeUnsupported, "cannot compile %T without a field", node)
+		}
+	}
+	if field == "" && len(c.opt.defaultFields) > 0 {
+		return c.opt.defaultFields, nil
+	}
+	if field != "" && !isFieldPattern(field) && !fieldAllowed(c, field) {
+		return nil, fieldNotAllowedError(field)
+	}
+	return []boostedField{{name: field, boost: noBoost}}, nil
+}
+
+// checkClauses counts the queries the syntax tree would be built from,
+// without building them, to check the limit on clauses
+func (c *compiler) checkClauses(node Node) {
+	if c.opt.maxClauses <= 0 || nilNode(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *BooleanNode:
+		for _, clause := range n.Clauses {
+			if clause != nil {
+				c.checkClauses(clause.Node)
+			}
+		}
+		return
+	case *MatchAllNode:
+		return
+	}
+	fields, err := c.nodeFields(node)
+	if err != nil {
+		return
+	}
+	expanded, _ := expandFields(c, fields)
+	for range expanded {
+		if !c.addClause(node) {
+			return
+		}
+	}
+}
+
+// compileField builds the query for a node, searching the field given
+// in place of the one in the node
+func (c *compiler) compileField(node Node, field string) (bluge.Query, error) {
+	switch n := node.(type) {
+	case *TermNode:
+		return queryStringStringToken(c, field, n.Term)
+	case *NumberNode:
+		return queryStringNumberToken(c, field, n.Value)
+	case *PhraseNode:
+		return queryStringPhraseToken(c, field, n.Phrase, n.Slop)
+	case *FuzzyNode:
+		return queryStringStringTokenFuzzy(c, field, n.Term, n.Fuzziness)
+	case *WildcardNode:
+		return queryStringWildcard(c, field, n.Pattern)
+	case *RegexpNode:
+		return queryStringRegexp(c, field, n.Pattern)
+	case *RangeNode:
+		r := *n
+		r.Field = field
+		return queryStringRange(c, &r)
+	case *ExistsNode:
+		return queryStringExists(c, field, n.Missing)
+	}
+	return nil, parseErrorf(ErrorCodeUnsupported, "cannot compile %T", node)
+}
+
+// compileFields builds the query for a node matching any of the fields,
+// the field of the node, or the default fields for a node without one,
+// after expanding aliases and patterns. Fields the node cannot be
+// searched in, like text in a numeric field, are left out, unless
+// that leaves none.
+func (c *compiler) compileFields(node Node, fields []boostedField) bluge.Query {
+	var queries []bluge.Query
+	var firstErr error
+	expanded, denied := expandFields(c, fields)
+	switch {
+	case len(expanded) > 0:
+	case denied != "":
+		firstErr = fieldNotAllowedError(denied)
+	default:
+		names := make([]string, len(fields))
+		for i, f := range fields {
+			names[i] = f.name
+		}
+		firstErr = parseErrorf(ErrorCodeUnsupported, "no fields match '%s'", strings.Join(names, "', '"))
+	}
+	for _, f := range expanded {
+		q, err := c.compileField(node, f.name)
+		if err == nil && f.boost != noBoost {
+			q, err = queryStringSetBoost(q, f.boost)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !c.addClause(node) {
+			return nil
+		}
+		queries = append(queries, q)
+	}
+	switch len(queries) {
+	case 0:
+		c.reportError(firstErr, node)
+		return nil
+	case 1:
+		return queries[0]
+	}
+	return bluge.NewBooleanQuery().AddShould(queries...)
+}
+
+// addClause counts a query searching a single field, reporting
+// an error for the first going over the limit on clauses
+func (c *compiler) addClause(node Node) bool {
+	c.clauses++
+	if c.opt.maxClauses <= 0 || c.clauses <= c.opt.maxClauses {
+		return true
+	}
+	if c.clauses == c.opt.maxClauses+1 {
+		c.reportError(limitErrorf("query has more than %d clauses", c.opt.maxClauses), node)
+	}
+	return false
+}
+
+func (c *compiler) compileBoolean(n *BooleanNode) bluge.Query {
+	rv := bluge.NewBooleanQuery()
+	for _, clause := range n.Clauses {
+		if clause == nil {
+			c.reportError(parseErrorf(ErrorCodeUnsupported, "cannot compile a missing clause"), n)
+			continue
+		}
+		q := c.compile(clause.Node)
+		if q == nil {
+			continue
+		}
+		if clause.Boost != nil {
+			var err error
+			// the query may already be boosted, by a boosted field
+			q, err = queryStringSetBoost(q, *clause.Boost*queryStringBoost(q))
+			if err != nil {
+				c.reportError(err, clause.Node)
+				continue
+			}
+		}
+		switch clause.Occur {
+		case OccurShould:
+			rv.AddShould(q)
+		case OccurMust:
+			rv.AddMust(q)
+		case OccurMustNot:
+			rv.AddMustNot(q)
+		}
+	}
+	if c.opt.negativeGroups && onlyMustNots(rv) {
+		rv.AddMust(bluge.NewMatchAllQuery())
+	}
+	if n.MinShould != "" {
+		min, err := queryStringMinShould(n.MinShould, len(rv.Shoulds()))
+		if err != nil {
+			c.reportError(err, n)
+		} else if min > 0 {
+			rv.SetMinShould(min)
+		}
+	}
+	return rv
+}
+
+func nodeField(node Node) string {
+	switch n := node.(type) {
+	case *TermNode:
+		return n.Field
+	case *NumberNode:
+		return n.Field
+	case *PhraseNode:
+		return n.Field
+	case *FuzzyNode:
+		return n.Field
+	case *WildcardNode:
+		return n.Field
+	case *RegexpNode:
+		return n.Field
+	case *RangeNode:
+		return n.Field
+	case *ExistsNode:
+		return n.Field
+	}
+	return ""
+}
+
+// reportError records a problem building the query for the node,
+// compiling carries on to find any others
+func (c *compiler) reportError(err error, node Node) {
+	var span Span
+	if !nilNode(node) {
+		span = node.Position()
+	}
+	c.errs = append(c.errs, errorAt(err, span))
+}
+
+// nilNode reports whether there is no node, or only a nil pointer to one,
+// which can happen in syntax trees that were not built by the parser
+func nilNode(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+func (c *compiler) logDebugAnalyzerf(format string, v ...interface{}) {
+	if c.opt.debugAnalyzer {
+		c.opt.logger.Printf(format, v...)
+	}
+}
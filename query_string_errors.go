@@ -0,0 +1,192 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querystr
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseErrorCode is a machine readable identifier for
+// the kind of problem described by a ParseError
+type ParseErrorCode string
+
+const (
+	// ErrorCodeSyntax is used when the query string does not follow the grammar
+	ErrorCodeSyntax ParseErrorCode = "syntax"
+	// ErrorCodeUnterminatedQuote is used when a phrase is missing its closing quote
+	ErrorCodeUnterminatedQuote ParseErrorCode = "unterminated_quote"
+	// ErrorCodeInvalidValue is used when a number, date, boost, fuzziness,
+	// slop or minimum should match value cannot be parsed
+	ErrorCodeInvalidValue ParseErrorCode = "invalid_value"
+	// ErrorCodeInvalidRange is used when the bounds of a range do not fit together
+	ErrorCodeInvalidRange ParseErrorCode = "invalid_range"
+	// ErrorCodeFieldType is used when a query is not supported by the
+	// type declared for its field
+	ErrorCodeFieldType ParseErrorCode = "field_type"
+	// ErrorCodeFieldNotAllowed is used when the query string searches
+	// a field it may not, the field is given by the Field of the error
+	ErrorCodeFieldNotAllowed ParseErrorCode = "field_not_allowed"
+	// ErrorCodeDisabled is used for syntax disabled by WithDisabledFeatures
+	ErrorCodeDisabled ParseErrorCode = "disabled"
+	// ErrorCodeLimit is used when the query string is too large,
+	// going over one of the limits in the options
+	ErrorCodeLimit ParseErrorCode = "limit"
+	// ErrorCodeUnsupported is used for any other query that cannot be built
+	ErrorCodeUnsupported ParseErrorCode = "unsupported"
+)
+
+// ParseError describes a problem with part of a query string.
+// Offset is in bytes, Line and Column count from 1, with
+// Column counting runes. Field is only set for problems
+// with the field itself.
+type ParseError struct {
+	Code     ParseErrorCode
+	Message  string
+	Token    string
+	Offset   int
+	Line     int
+	Column   int
+	Expected []string
+	Field    string
+
+	end int
+}
+
+func parseErrorf(code ParseErrorCode, format string, v ...interface{}) *ParseError {
+	return &ParseError{
+		Code:    code,
+		Message: fmt.Sprintf(format, v...),
+	}
+}
+
+// errorAt converts err to a ParseError for the input in span
+func errorAt(err error, span Span) *ParseError {
+	pe, ok := err.(*ParseError)
+	if !ok {
+		pe = parseErrorf(ErrorCodeUnsupported, "%v", err)
+	}
+	pe.Offset, pe.end = span.Start, span.End
+	return pe
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s (line %d, column %d)", e.Message, e.Line, e.Column)
+}
+
+// locate fills in the position details of the error from the query
+func (e *ParseError) locate(query string) {
+	locateErrors(query, []*ParseError{e})
+}
+
+// locateErrors fills in the position details of errors in order
+// of offset, counting lines and columns from one to the next
+func locateErrors(query string, errs []*ParseError) {
+	line, column, offset := 1, 1, 0
+	for _, e := range errs {
+		if e.end > len(query) {
+			e.end = len(query)
+		}
+		if e.Offset > e.end {
+			e.Offset = e.end
+		}
+		e.Token = query[e.Offset:e.end]
+		before := query[offset:e.Offset]
+		if i := strings.LastIndexByte(before, '\n'); i >= 0 {
+			line += strings.Count(before, "\n")
+			column = 1
+			before = before[i+1:]
+		}
+		column += utf8.RuneCountInString(before)
+		offset = e.Offset
+		e.Line, e.Column = line, column
+	}
+}
+
+// ParseErrors is the error returned by ParseQueryString,
+// it lists every problem found, in the order they were found
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// As allows errors.As to retrieve the first ParseError
+func (e ParseErrors) As(target interface{}) bool {
+	if t, ok := target.(**ParseError); ok && len(e) > 0 {
+		*t = e[0]
+		return true
+	}
+	return false
+}
+
+// tokenDescriptions are used in place of the grammar token names
+// when describing syntax errors
+var tokenDescriptions = map[string]string{
+	"$end":          "end of input",
+	"tSTRING":       "term",
+	"tPHRASE":       "phrase",
+	"tNUMBER":       "number",
+	"tPLUS":         "'+'",
+	"tMINUS":        "'-'",
+	"tCOLON":        "':'",
+	"tBOOST":        "boost",
+	"tTILDE":        "'~'",
+	"tGREATER":      "'>'",
+	"tLESS":         "'<'",
+	"tEQUAL":        "'='",
+	"tLEFTPAREN":    "'('",
+	"tRIGHTPAREN":   "')'",
+	"tAND":          "AND",
+	"tOR":           "OR",
+	"tNOT":          "NOT",
+	"tLEFTBRACKET":  "'['",
+	"tRIGHTBRACKET": "']'",
+	"tLEFTBRACE":    "'{'",
+	"tRIGHTBRACE":   "'}'",
+	"tTO":           "TO",
+	"tMINSHOULD":    "minimum should match",
+}
+
+func describeToken(name string) string {
+	if desc, ok := tokenDescriptions[name]; ok {
+		return desc
+	}
+	return name
+}
+
+// syntaxError builds a ParseError from a verbose yacc error message,
+// which looks like "syntax error: unexpected tCOLON, expecting tSTRING or tPHRASE"
+func syntaxError(msg string) *ParseError {
+	rv := parseErrorf(ErrorCodeSyntax, "syntax error")
+	i := strings.Index(msg, "unexpected ")
+	if i < 0 {
+		return rv
+	}
+	parts := strings.SplitN(msg[i+len("unexpected "):], ", expecting ", 2)
+	rv.Message += ": unexpected " + describeToken(parts[0])
+	if len(parts) == 2 {
+		for _, name := range strings.Split(parts[1], " or ") {
+			rv.Expected = append(rv.Expected, describeToken(name))
+		}
+		rv.Message += ", expecting " + strings.Join(rv.Expected, " or ")
+	}
+	return rv
+}
@@ -0,0 +1,422 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querystr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/blugelabs/bluge"
+)
+
+// Format serializes a query built by this package back into query string
+// syntax, parsing the result with the same options builds an equal query.
+// Boosts of 1 are left out, as are analyzers, which come from the options.
+// With the AND default operator optional clauses are joined by OR, which
+// matches the same documents, although groups may be nested differently.
+func Format(q bluge.Query, options QueryStringOptions) (string, error) {
+	if _, ok := q.(*bluge.MatchNoneQuery); ok {
+		if options.emptyMatchesAll {
+			return "", fmt.Errorf("cannot format match none query when empty matches all")
+		}
+		return "", nil
+	}
+	d := &decompiler{opt: &options}
+	if bq, ok := q.(*bluge.BooleanQuery); ok && bq.Boost() == noBoost && d.minShould(bq) == "" {
+		root, err := d.boolean(bq)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(root), nil
+	}
+	c, err := d.clause(OccurShould, q)
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
+// decompiler turns bluge queries back into the nodes they were compiled from
+type decompiler struct {
+	opt *QueryStringOptions
+}
+
+func (d *decompiler) clause(occur Occur, q bluge.Query) (*Clause, error) {
+	var node Node
+	var err error
+	switch q := q.(type) {
+	case *bluge.BooleanQuery:
+		if n := d.number(q); n != nil {
+			node = n
+		} else if n := d.missing(q); n != nil {
+			node = n
+		} else {
+			node, err = d.boolean(q)
+		}
+	case *bluge.MatchQuery:
+		if q.Prefix() != 0 || q.Operator() != bluge.MatchQueryOperatorOr {
+			return nil, fmt.Errorf("cannot format match query with prefix or operator")
+		}
+		if q.Fuzziness() != 0 {
+			node = &FuzzyNode{Field: q.Field(), Term: q.Match(), Fuzziness: q.Fuzziness()}
+		} else {
+			node = &TermNode{Field: q.Field(), Term: q.Match()}
+		}
+	case *bluge.TermQuery:
+		node = &TermNode{Field: q.Field(), Term: q.Term()}
+	case *bluge.FuzzyQuery:
+		if q.Prefix() != 0 {
+			return nil, fmt.Errorf("cannot format fuzzy query with prefix")
+		}
+		node = &FuzzyNode{Field: q.Field(), Term: q.Term(), Fuzziness: q.Fuzziness()}
+	case *bluge.MatchPhraseQuery:
+		node = &PhraseNode{Field: q.Field(), Phrase: q.Phrase(), Slop: q.Slop()}
+	case *bluge.WildcardQuery:
+		node = &WildcardNode{Field: q.Field(), Pattern: q.Wildcard()}
+	case *bluge.RegexpQuery:
+		node = &RegexpNode{Field: q.Field(), Pattern: q.Regexp()}
+	case *bluge.NumericRangeQuery:
+		node, err = d.numericRange(q)
+	case *bluge.DateRangeQuery:
+		node, err = d.dateRange(q)
+	case *bluge.TermRangeQuery:
+		node, err = d.termRange(q)
+	case *bluge.MatchAllQuery:
+		node = &MatchAllNode{}
+	case *bluge.PrefixQuery:
+		if q.Prefix() != "" || q.Field() == "" {
+			return nil, fmt.Errorf("cannot format prefix query")
+		}
+		node = &ExistsNode{Field: q.Field()}
+	default:
+		return nil, fmt.Errorf("cannot format %T", q)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rv := &Clause{Occur: occur, Node: node}
+	if b, ok := q.(interface{ Boost() float64 }); ok && b.Boost() != noBoost {
+		boost := b.Boost()
+		rv.Boost = &boost
+	}
+	return rv, nil
+}
+
+// rangeNode starts the node for a range, which can only be
+// written in the query string syntax with a field
+func rangeNode(field string) (*RangeNode, error) {
+	if field == "" {
+		return nil, fmt.Errorf("cannot format range query without a field")
+	}
+	return &RangeNode{Field: field}, nil
+}
+
+func (d *decompiler) boolean(q *bluge.BooleanQuery) (Node, error) {
+	rv := &BooleanNode{MinShould: d.minShould(q)}
+	add := func(occur Occur, queries []bluge.Query) error {
+		for _, cq := range queries {
+			c, err := d.clause(occur, cq)
+			if err != nil {
+				return err
+			}
+			rv.Clauses = append(rv.Clauses, c)
+		}
+		return nil
+	}
+	if err := add(OccurMust, q.Musts()); err != nil {
+		return nil, err
+	}
+	if err := add(OccurShould, q.Shoulds()); err != nil {
+		return nil, err
+	}
+	if err := add(OccurMustNot, q.MustNots()); err != nil {
+		return nil, err
+	}
+	if d.opt.defaultOperator == OperatorAnd && len(q.Shoulds()) > 0 {
+		// unprefixed clauses are required, so optional
+		// ones can only be written joined by OR
+		if len(q.Musts()) > 0 {
+			return nil, fmt.Errorf("cannot format optional clauses alongside required ones with the AND default operator")
+		}
+		if rv.MinShould != "" {
+			return nil, fmt.Errorf("cannot format minimum should with the AND default operator")
+		}
+		op := "OR"
+		if !d.opt.keywordOperators {
+			op = "||"
+		}
+		return &orNode{BooleanNode: rv, op: op}, nil
+	}
+	return rv, nil
+}
+
+// minShould returns how many optional clauses of the query have to match,
+// as written after a group, which is left out when the options would
+// give the same number anyway
+func (d *decompiler) minShould(q *bluge.BooleanQuery) string {
+	implied := 0
+	if d.opt.minShould != "" {
+		implied, _ = queryStringMinShould(d.opt.minShould, len(q.Shoulds()))
+	}
+	if q.MinShould() == implied {
+		return ""
+	}
+	return strconv.Itoa(q.MinShould())
+}
+
+// number recognizes the query built for a number in a field
+// not in the schema, which matches the text or the numeric value
+func (d *decompiler) number(q *bluge.BooleanQuery) *NumberNode {
+	if len(q.Musts()) != 0 || len(q.MustNots()) != 0 || len(q.Shoulds()) != 2 || q.MinShould() != 0 {
+		return nil
+	}
+	mq, ok := q.Shoulds()[0].(*bluge.MatchQuery)
+	if !ok || mq.Boost() != noBoost || mq.Fuzziness() != 0 {
+		return nil
+	}
+	nq, ok := q.Shoulds()[1].(*bluge.NumericRangeQuery)
+	if !ok || nq.Boost() != noBoost || nq.Field() != mq.Field() {
+		return nil
+	}
+	val, err := strconv.ParseFloat(mq.Match(), 64)
+	min, minInclusive := nq.Min()
+	max, maxInclusive := nq.Max()
+	if err != nil || val != min || val != max || !minInclusive || !maxInclusive {
+		return nil
+	}
+	return &NumberNode{Field: mq.Field(), Value: mq.Match()}
+}
+
+// missing recognizes the query built for documents without a value
+func (d *decompiler) missing(q *bluge.BooleanQuery) *ExistsNode {
+	if len(q.Musts()) != 1 || len(q.MustNots()) != 1 || len(q.Shoulds()) != 0 || q.MinShould() != 0 {
+		return nil
+	}
+	mq, ok := q.Musts()[0].(*bluge.MatchAllQuery)
+	if !ok || mq.Boost() != noBoost {
+		return nil
+	}
+	pq, ok := q.MustNots()[0].(*bluge.PrefixQuery)
+	if !ok || pq.Boost() != noBoost || pq.Prefix() != "" || pq.Field() == "" {
+		return nil
+	}
+	return &ExistsNode{Field: pq.Field(), Missing: true}
+}
+
+func (d *decompiler) numericRange(q *bluge.NumericRangeQuery) (*RangeNode, error) {
+	rv, err := rangeNode(q.Field())
+	if err != nil {
+		return nil, err
+	}
+	min, minInclusive := q.Min()
+	if !math.IsInf(min, -1) {
+		rv.Min = &RangeBound{Kind: NumberValue, Value: strconv.FormatFloat(min, 'f', -1, 64)}
+		rv.MinInclusive = minInclusive
+	}
+	max, maxInclusive := q.Max()
+	if !math.IsInf(max, 1) {
+		rv.Max = &RangeBound{Kind: NumberValue, Value: strconv.FormatFloat(max, 'f', -1, 64)}
+		rv.MaxInclusive = maxInclusive
+	}
+	return rv, nil
+}
+
+func (d *decompiler) dateRange(q *bluge.DateRangeQuery) (*RangeNode, error) {
+	rv, err := rangeNode(q.Field())
+	if err != nil {
+		return nil, err
+	}
+	start, startInclusive := q.Start()
+	if !start.IsZero() {
+		rv.Min = &RangeBound{Kind: PhraseValue, Value: start.Format(d.opt.dateFormat)}
+		rv.MinInclusive = startInclusive
+	}
+	end, endInclusive := q.End()
+	if !end.IsZero() {
+		rv.Max = &RangeBound{Kind: PhraseValue, Value: end.Format(d.opt.dateFormat)}
+		rv.MaxInclusive = endInclusive
+	}
+	return rv, nil
+}
+
+func (d *decompiler) termRange(q *bluge.TermRangeQuery) (*RangeNode, error) {
+	rv, err := rangeNode(q.Field())
+	if err != nil {
+		return nil, err
+	}
+	min, minInclusive := q.Min()
+	if min != "" {
+		rv.Min = &RangeBound{Kind: TermValue, Value: min}
+		rv.MinInclusive = minInclusive
+	}
+	max, maxInclusive := q.Max()
+	if max != "" {
+		rv.Max = &RangeBound{Kind: TermValue, Value: max}
+		rv.MaxInclusive = maxInclusive
+	}
+	return rv, nil
+}
+
+func fieldPrefix(field string) string {
+	if field == "" {
+		return ""
+	}
+	return Escape(field) + ":"
+}
+
+// String formats the clauses of the node, separated by spaces, without
+// the parentheses and minimum should match a nested group is written with
+func (n *BooleanNode) String() string {
+	clauses := make([]string, len(n.Clauses))
+	for i, c := range n.Clauses {
+		clauses[i] = c.String()
+	}
+	return strings.Join(clauses, " ")
+}
+
+// orNode is a boolean node formatted as a chain of OR, for when the
+// default operator is AND
+type orNode struct {
+	*BooleanNode
+	op string
+}
+
+func (n *orNode) String() string {
+	clauses := make([]string, len(n.Clauses))
+	for i, c := range n.Clauses {
+		clauses[i] = c.String()
+	}
+	return strings.Join(clauses, " "+n.op+" ")
+}
+
+func (c *Clause) String() string {
+	var sb strings.Builder
+	switch c.Occur {
+	case OccurMust:
+		sb.WriteByte('+')
+	case OccurMustNot:
+		sb.WriteByte('-')
+	}
+	switch n := c.Node.(type) {
+	case *BooleanNode:
+		sb.WriteString("(" + n.String() + ")")
+		if n.MinShould != "" {
+			sb.WriteString("@" + n.MinShould)
+		}
+	case *orNode:
+		if c.Occur == OccurMust && c.Boost == nil {
+			// a chain of OR occurs like an unprefixed clause
+			return n.String()
+		}
+		sb.WriteString("(" + n.String() + ")")
+	default:
+		sb.WriteString(fmt.Sprint(c.Node))
+	}
+	if c.Boost != nil {
+		sb.WriteString("^" + strconv.FormatFloat(*c.Boost, 'f', -1, 64))
+	}
+	return sb.String()
+}
+
+func (n *TermNode) String() string {
+	return fieldPrefix(n.Field) + Escape(n.Term)
+}
+
+func (n *NumberNode) String() string {
+	if n.Field == "" && strings.HasPrefix(n.Value, "-") {
+		// a leading minus would be taken as a prefix
+		return Escape(n.Value)
+	}
+	return fieldPrefix(n.Field) + n.Value
+}
+
+func (n *PhraseNode) String() string {
+	rv := fieldPrefix(n.Field) + QuotePhrase(n.Phrase)
+	if n.Slop != 0 {
+		rv += "~" + strconv.Itoa(n.Slop)
+	}
+	return rv
+}
+
+func (n *FuzzyNode) String() string {
+	return fieldPrefix(n.Field) + Escape(n.Term) + "~" + strconv.Itoa(n.Fuzziness)
+}
+
+func (n *WildcardNode) String() string {
+	return fieldPrefix(n.Field) + escapeTerm(n.Pattern, "*?")
+}
+
+func (n *RegexpNode) String() string {
+	return fieldPrefix(n.Field) + "/" + escape(n.Pattern, "") + "/"
+}
+
+func (n *RangeNode) String() string {
+	rv := fieldPrefix(n.Field)
+	switch {
+	case n.Max == nil && n.Min != nil:
+		rv += ">"
+		if n.MinInclusive {
+			rv += "="
+		}
+		return rv + n.Min.String()
+	case n.Min == nil && n.Max != nil:
+		rv += "<"
+		if n.MaxInclusive {
+			rv += "="
+		}
+		return rv + n.Max.String()
+	}
+	if n.MinInclusive {
+		rv += "["
+	} else {
+		rv += "{"
+	}
+	rv += n.Min.String() + " TO " + n.Max.String()
+	if n.MaxInclusive {
+		return rv + "]"
+	}
+	return rv + "}"
+}
+
+func (n *MatchAllNode) String() string {
+	return "*"
+}
+
+func (n *ExistsNode) String() string {
+	// an escaped pattern character would make the field a literal term
+	field := escapeTerm(n.Field, "*?/")
+	if n.Missing {
+		return "_missing_:" + field
+	}
+	return "_exists_:" + field
+}
+
+// String formats the bound as it is written in a range,
+// a nil bound is written as *
+func (b *RangeBound) String() string {
+	if b == nil {
+		return "*"
+	}
+	switch b.Kind {
+	case NumberValue:
+		return b.Value
+	case PhraseValue:
+		return QuotePhrase(b.Value)
+	}
+	return Escape(b.Value)
+}
@@ -0,0 +1,178 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querystr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Warning describes part of a query string which could not be
+// parsed when lenient, and was searched for as literal text instead,
+// or syntax which was disabled and could be left out, like a boost
+type Warning struct {
+	// Literal is the text searched for, it is empty
+	// when the text blamed by Cause was left out
+	Literal string
+	// Offset is the byte offset of Literal in the query string
+	Offset int
+	// Cause is the problem found with the text
+	Cause *ParseError
+}
+
+func (w *Warning) String() string {
+	if w.Literal == "" {
+		return fmt.Sprintf("left out '%s': %v", w.Cause.Token, w.Cause)
+	}
+	return fmt.Sprintf("searched for '%s' as text: %v", w.Literal, w.Cause)
+}
+
+func (w *Warning) end() int {
+	return w.Offset + len(w.Literal)
+}
+
+// literalSpans maps the start of each literal to its end, for the lexer
+func literalSpans(warnings []*Warning) map[int]int {
+	rv := make(map[int]int, len(warnings))
+	for _, w := range warnings {
+		rv[w.Offset] = w.end()
+	}
+	return rv
+}
+
+// addLiteralWarning makes the text blamed for the error literal,
+// it returns false if there is no more text which could be
+func addLiteralWarning(query string, warnings []*Warning, e *ParseError) ([]*Warning, bool) {
+	start, end := e.Offset, e.end
+	if e.Code == ErrorCodeSyntax {
+		// syntax errors are only noticed at a single token,
+		// so take the whole word around it
+		start = strings.LastIndexByte(query[:start], ' ') + 1
+		if i := strings.IndexByte(query[end:], ' '); i >= 0 {
+			end += i
+		} else {
+			end = len(query)
+		}
+	}
+
+	// if the text is already literal, or there is none, the
+	// problem started earlier, so take in the word before it
+	last := len(strings.TrimRight(query, " "))
+	for end = trimEnd(query, start, end); literalCovers(warnings, start, end); end = trimEnd(query, start, end) {
+		if start == 0 {
+			if end >= last {
+				return warnings, false
+			}
+			end = last
+			continue
+		}
+		start = strings.LastIndexByte(strings.TrimRight(query[:start], " "), ' ') + 1
+	}
+
+	// merge with any literals it overlaps, the error found
+	// first is kept as the cause, later ones may only be
+	// the result of making it literal, the warnings are
+	// kept in order and never overlap or touch
+	i := sort.Search(len(warnings), func(i int) bool {
+		return warnings[i].end() >= start
+	})
+	j := i
+	cause := e
+	for ; j < len(warnings) && warnings[j].Offset <= end; j++ {
+		if j == i {
+			cause = warnings[j].Cause
+		}
+		if warnings[j].Offset < start {
+			start = warnings[j].Offset
+		}
+		if warnings[j].end() > end {
+			end = warnings[j].end()
+		}
+	}
+	w := &Warning{
+		Literal: query[start:end],
+		Offset:  start,
+		Cause:   cause,
+	}
+	if i == len(warnings) {
+		// errors are mostly found in order, so the warning
+		// usually goes after those there are already
+		return append(warnings, w), true
+	}
+	rv := make([]*Warning, 0, len(warnings)-(j-i)+1)
+	rv = append(rv, warnings[:i]...)
+	rv = append(rv, w)
+	return append(rv, warnings[j:]...), true
+}
+
+// ignoredWarnings adds a warning for each part of the query
+// which was left out, keeping the warnings in order
+func ignoredWarnings(query string, warnings []*Warning, ignored []*ParseError) []*Warning {
+	if len(ignored) == 0 {
+		return warnings
+	}
+	rv := append([]*Warning(nil), warnings...)
+	for _, e := range ignored {
+		e.locate(query)
+		rv = append(rv, &Warning{Offset: e.Offset, Cause: e})
+	}
+	sort.SliceStable(rv, func(i, j int) bool {
+		return rv[i].Offset < rv[j].Offset
+	})
+	return rv
+}
+
+// literalSince reports whether the error is in text which has been made
+// literal for another error found in the same pass, which may be the
+// cause of it, so it is left to the next pass
+func literalSince(before, after []*Warning, e *ParseError) bool {
+	return literalCovers(after, e.Offset, e.end) && !literalCovers(before, e.Offset, e.end)
+}
+
+// literalQuery makes the whole query string literal
+func literalQuery(query string, e *ParseError) []*Warning {
+	start := len(query) - len(strings.TrimLeft(query, " "))
+	return []*Warning{{
+		Literal: strings.TrimRight(query[start:], " "),
+		Offset:  start,
+		Cause:   e,
+	}}
+}
+
+// literalOverlaps reports whether any byte between
+// start and end is already part of a literal
+func literalOverlaps(warnings []*Warning, start, end int) bool {
+	i := sort.Search(len(warnings), func(i int) bool {
+		return warnings[i].end() > start
+	})
+	return i < len(warnings) && warnings[i].Offset < end
+}
+
+func trimEnd(query string, start, end int) int {
+	return start + len(strings.TrimRight(query[start:end], " "))
+}
+
+// literalCovers reports whether every byte between start
+// and end is already part of a literal
+func literalCovers(warnings []*Warning, start, end int) bool {
+	i := sort.Search(len(warnings), func(i int) bool {
+		return warnings[i].end() > start
+	})
+	if i < len(warnings) && warnings[i].Offset <= start {
+		start = warnings[i].end()
+	}
+	return start >= end
+}
@@ -20,6 +20,7 @@ import (
 	"log"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 const reservedChars = "+-=&|><!(){}[]^\"~*?:\\/ "
@@ -33,38 +34,140 @@ func unescape(escaped string) string {
 	return "\\" + escaped
 }
 
+// unescapeFirst is unescape for the first character of a term, which
+// Escape also escapes when it starts a number, a keyword or a space
+func unescapeFirst(first rune) string {
+	if unicode.IsDigit(first) || unicode.IsSpace(first) || strings.ContainsRune("AONT", first) {
+		return string(first)
+	}
+	return unescape(string(first))
+}
+
+// Escape returns the term written so that it is read as a single term with
+// exactly its value, rather than as query string syntax, every reserved
+// character is escaped, and the first character is escaped as well when
+// the term would otherwise be read as a number or a keyword. The empty
+// term cannot be written, use QuotePhrase instead.
+func Escape(term string) string {
+	return escapeTerm(term, "")
+}
+
+// escapeTerm is Escape, other than for the reserved characters in keep,
+// which are left for the term to be read as a pattern
+func escapeTerm(term, keep string) string {
+	first, size := utf8.DecodeRuneInString(term)
+	if term == "" || strings.ContainsRune(reservedChars, first) || !escapeFirst(term, first) {
+		return escape(term, keep)
+	}
+	return "\\" + term[:size] + escape(term[size:], keep)
+}
+
+// escapeFirst reports whether the first character of the term needs
+// escaping, even though it is not a reserved character
+func escapeFirst(term string, first rune) bool {
+	_, keyword := keywordOperators[term]
+	return keyword || term == "TO" || unicode.IsDigit(first) || unicode.IsSpace(first)
+}
+
+// QuotePhrase returns the text in double quotes, escaped so
+// that it is read as a single phrase with exactly its value
+func QuotePhrase(text string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range text {
+		if r == '"' || r == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// escape puts a backslash before each reserved character in str, other
+// than those in keep, so that they are not read as query string syntax
+func escape(str, keep string) string {
+	var sb strings.Builder
+	for _, r := range str {
+		if strings.ContainsRune(reservedChars, r) && !strings.ContainsRune(keep, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// keywordOperators are the boolean operators that can be
+// spelled out as a whole, unescaped, uppercase term
+var keywordOperators = map[string]int{
+	"AND": tAND,
+	"OR":  tOR,
+	"NOT": tNOT,
+}
+
 type queryStringLex struct {
-	in            *bufio.Reader
-	buf           string
-	currState     lexState
-	currConsumed  bool
-	inEscape      bool
-	nextToken     *yySymType
-	nextTokenType int
-	seenDot       bool
-	nextRune      rune
-	nextRuneSize  int
-	atEOF         bool
-	debugLexer    bool
-	logger        *log.Logger
+	in             *bufio.Reader
+	buf            string
+	currState      lexState
+	currConsumed   bool
+	inEscape       bool
+	escaped        bool
+	pattern        bool
+	escapedPattern bool
+	nextToken      *yySymType
+	nextTokenType  int
+	lastTokenType  int
+	seenDot        bool
+	inRange        bool
+	parenDepth     int
+	fieldGroups    []int
+	termParens     int
+	nextRune       rune
+	nextRuneSize   int
+	runeOffset     int
+	offset         int
+	tokenStart     int
+	tokenEnd       int
+	literals       map[int]int
+	literalEnd     int
+	atEOF          bool
+	debugLexer     bool
+	keywords       bool
+	logger         *log.Logger
 }
 
 func (l *queryStringLex) reset() {
 	l.buf = ""
 	l.inEscape = false
+	l.escaped = false
+	l.pattern = false
+	l.escapedPattern = false
 	l.seenDot = false
+	l.termParens = 0
 }
 
 func (l *queryStringLex) Error(msg string) {
 	panic(msg)
 }
 
+// fail aborts lexing with a ParseError starting at the current token
+func (l *queryStringLex) fail(code ParseErrorCode, msg string) {
+	panic(&ParseError{
+		Code:    code,
+		Message: msg,
+		Offset:  l.tokenStart,
+		end:     l.offset,
+	})
+}
+
 func (l *queryStringLex) Lex(lval *yySymType) int {
 	var err error
 
 	for l.nextToken == nil {
 		if l.currConsumed {
+			l.runeOffset = l.offset
 			l.nextRune, l.nextRuneSize, err = l.in.ReadRune()
+			l.offset += l.nextRuneSize
 			if err != nil && err == io.EOF {
 				l.nextRune = 0
 				l.atEOF = true
@@ -74,14 +177,23 @@ func (l *queryStringLex) Lex(lval *yySymType) int {
 		}
 		l.currState, l.currConsumed = l.currState(l, l.nextRune, l.atEOF)
 		if l.currState == nil {
+			l.tokenStart, l.tokenEnd = l.runeOffset, l.runeOffset
 			return 0
 		}
 	}
 
+	// the rune that ended the token is part of it when consumed,
+	// unless it was the space separating it from the next token
+	l.tokenEnd = l.runeOffset
+	if l.currConsumed && !l.atEOF && l.nextRune != ' ' {
+		l.tokenEnd += l.nextRuneSize
+	}
+
 	*lval = *l.nextToken
 	rv := l.nextTokenType
 	l.nextToken = nil
 	l.nextTokenType = 0
+	l.lastTokenType = rv
 	return rv
 }
 
@@ -91,6 +203,7 @@ func newQueryStringLex(in io.Reader, options QueryStringOptions) *queryStringLex
 		currState:    startState,
 		currConsumed: true,
 		debugLexer:   options.debugLexer,
+		keywords:     options.keywordOperators,
 		logger:       options.logger,
 	}
 }
@@ -102,21 +215,61 @@ func startState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
 		return nil, false
 	}
 
-	// handle inside escape case up front
+	if !l.inEscape {
+		l.tokenStart = l.runeOffset
+		if end, ok := l.literals[l.runeOffset]; ok {
+			l.literalEnd = end
+			l.buf += string(next)
+			return inLiteralState, true
+		}
+	}
+
+	// handle inside escape case up front, the start of a term
+	// can be escaped to keep it from being read as a number or
+	// keyword, as well as any reserved character
 	if l.inEscape {
 		l.inEscape = false
-		l.buf += unescape(string(next))
+		l.escaped = true
+		l.notePattern(next, true)
+		l.buf += unescapeFirst(next)
 		return inStrState, true
 	}
 
 	switch next {
 	case '"':
 		return inPhraseState, true
-	case '+', '-', ':', '>', '<', '=':
+	case '+', '-', ':', '>', '<', '=', '(', '!':
 		l.buf += string(next)
 		return singleCharOpState, true
+	case '&', '|':
+		l.buf += string(next)
+		return doubleCharOpState, true
+	case ')':
+		// a close paren only means something inside a group
+		if l.parenDepth > 0 {
+			l.buf += string(next)
+			return singleCharOpState, true
+		}
+	case '[', '{':
+		// ranges only start as a field value,
+		// or in a group written after a field
+		if l.lastTokenType == tCOLON || l.inFieldGroup() {
+			l.buf += string(next)
+			return singleCharOpState, true
+		}
+	case ']', '}':
+		if l.inRange {
+			l.buf += string(next)
+			return singleCharOpState, true
+		}
 	case '^':
 		return inBoostState, true
+	case '@':
+		// a minimum should match directly follows a group,
+		// otherwise @ is part of a term, like an email address
+		if l.lastTokenType == tRIGHTPAREN && l.tokenEnd == l.runeOffset {
+			return inMinShouldState, true
+		}
 	case '~':
 		return inTildeState, true
 	}
@@ -129,6 +282,7 @@ func startState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
 		l.buf += string(next)
 		return inNumOrStrState, true
 	case !unicode.IsSpace(next):
+		l.notePattern(next, false)
 		l.buf += string(next)
 		return inStrState, true
 	}
@@ -141,7 +295,7 @@ func startState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
 func inPhraseState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
 	// unterminated phrase eats the phrase
 	if eof {
-		l.Error("unterminated quote")
+		l.fail(ErrorCodeUnterminatedQuote, "unterminated quote")
 		return nil, false
 	}
 
@@ -168,6 +322,24 @@ func inPhraseState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
 	return inPhraseState, true
 }
 
+// inLiteralState takes everything up to the end of the
+// literal text without interpreting any of it
+func inLiteralState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
+	if eof || l.runeOffset >= l.literalEnd {
+		l.nextTokenType = tSTRING
+		l.nextToken = &yySymType{
+			s:   l.buf,
+			lit: true,
+		}
+		l.logDebugTokensf("LITERAL - '%s'", l.nextToken.s)
+		l.reset()
+		return startState, false
+	}
+
+	l.buf += string(next)
+	return inLiteralState, true
+}
+
 func singleCharOpState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
 	l.nextToken = &yySymType{}
 
@@ -190,36 +362,91 @@ func singleCharOpState(l *queryStringLex, next rune, eof bool) (lexState, bool)
 	case "=":
 		l.nextTokenType = tEQUAL
 		l.logDebugTokensf("EQUAL")
+	case "(":
+		l.parenDepth++
+		if l.lastTokenType == tCOLON {
+			l.fieldGroups = append(l.fieldGroups, l.parenDepth)
+		}
+		l.nextTokenType = tLEFTPAREN
+		l.logDebugTokensf("LEFTPAREN")
+	case ")":
+		if l.inFieldGroup() && l.fieldGroups[len(l.fieldGroups)-1] == l.parenDepth {
+			l.fieldGroups = l.fieldGroups[:len(l.fieldGroups)-1]
+		}
+		l.parenDepth--
+		l.nextTokenType = tRIGHTPAREN
+		l.logDebugTokensf("RIGHTPAREN")
+	case "!":
+		l.nextTokenType = tNOT
+		l.logDebugTokensf("NOT")
+	case "[":
+		l.inRange = true
+		l.nextTokenType = tLEFTBRACKET
+		l.logDebugTokensf("LEFTBRACKET")
+	case "]":
+		l.inRange = false
+		l.nextTokenType = tRIGHTBRACKET
+		l.logDebugTokensf("RIGHTBRACKET")
+	case "{":
+		l.inRange = true
+		l.nextTokenType = tLEFTBRACE
+		l.logDebugTokensf("LEFTBRACE")
+	case "}":
+		l.inRange = false
+		l.nextTokenType = tRIGHTBRACE
+		l.logDebugTokensf("RIGHTBRACE")
 	}
 
 	l.reset()
 	return startState, false
 }
 
+func doubleCharOpState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
+	// a single & or | is just the start of a string
+	if eof || next != rune(l.buf[0]) {
+		return inStrState, false
+	}
+
+	l.nextToken = &yySymType{}
+	switch l.buf {
+	case "&":
+		l.nextTokenType = tAND
+		l.logDebugTokensf("AND")
+	case "|":
+		l.nextTokenType = tOR
+		l.logDebugTokensf("OR")
+	}
+
+	l.reset()
+	return startState, true
+}
+
 func inBoostState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
 	return inBoostOrTildeState(l, next, eof, tBOOST, "BOOST", inBoostState)
 }
 
 func inTildeState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
+	// a boost may follow the fuzziness or slop
+	if !eof && !l.inEscape && next == '^' {
+		l.endBoostOrTilde(tTILDE, "TILDE")
+		return startState, false
+	}
 	return inBoostOrTildeState(l, next, eof, tTILDE, "TILDE", inTildeState)
 }
 
 func inBoostOrTildeState(l *queryStringLex, next rune, eof bool, nextTokenType int, name string,
 	inState lexState) (lexState, bool) {
 
-	// only a non-escaped space ends the boost (or eof)
-	if eof || (!l.inEscape && next == ' ') {
-		// end boost or tilde
-		l.nextTokenType = nextTokenType
-		if l.buf == "" {
-			l.buf = "1"
-		}
-		l.nextToken = &yySymType{
-			s: l.buf,
+	// only a non-escaped space or group close ends the boost (or eof)
+	if eof || (!l.inEscape && (next == ' ' || l.endsGroup(next))) {
+		l.endBoostOrTilde(nextTokenType, name)
+
+		consumed := true
+		if !eof && next == ')' {
+			consumed = false
 		}
-		l.logDebugTokensf("%s - '%s'", name, l.nextToken.s)
-		l.reset()
-		return startState, true
+
+		return startState, consumed
 	} else if !l.inEscape && next == '\\' {
 		l.inEscape = true
 	} else if l.inEscape {
@@ -233,9 +460,37 @@ func inBoostOrTildeState(l *queryStringLex, next rune, eof bool, nextTokenType i
 	return inState, true
 }
 
+// endBoostOrTilde ends a boost or tilde token, which
+// without a value is 1
+func (l *queryStringLex) endBoostOrTilde(nextTokenType int, name string) {
+	l.nextTokenType = nextTokenType
+	if l.buf == "" {
+		l.buf = "1"
+	}
+	l.nextToken = &yySymType{
+		s: l.buf,
+	}
+	l.logDebugTokensf("%s - '%s'", name, l.nextToken.s)
+	l.reset()
+}
+
+func inMinShouldState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
+	// a boost may follow the minimum should match
+	if !eof && !l.inEscape && next == '^' {
+		l.nextTokenType = tMINSHOULD
+		l.nextToken = &yySymType{
+			s: l.buf,
+		}
+		l.logDebugTokensf("MINSHOULD - '%s'", l.nextToken.s)
+		l.reset()
+		return startState, false
+	}
+	return inBoostOrTildeState(l, next, eof, tMINSHOULD, "MINSHOULD", inMinShouldState)
+}
+
 func inNumOrStrState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
-	// end on non-escaped space, colon, tilde, boost (or eof)
-	if eof || (!l.inEscape && (next == ' ' || next == ':' || next == '^' || next == '~')) {
+	// end on non-escaped space, colon, tilde, boost, group or range close (or eof)
+	if eof || (!l.inEscape && l.endsTerm(next)) {
 		// end number
 		l.nextTokenType = tNUMBER
 		l.nextToken = &yySymType{
@@ -244,10 +499,7 @@ func inNumOrStrState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
 		l.logDebugTokensf("NUMBER - '%s'", l.nextToken.s)
 		l.reset()
 
-		consumed := true
-		if !eof && (next == ':' || next == '^' || next == '~') {
-			consumed = false
-		}
+		consumed := eof || next == ' '
 
 		return startState, consumed
 	} else if !l.inEscape && next == '\\' {
@@ -256,6 +508,7 @@ func inNumOrStrState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
 	} else if l.inEscape {
 		// if in escape, end it
 		l.inEscape = false
+		l.notePattern(next, true)
 		l.buf += unescape(string(next))
 		// go directly to string, no successfully or unsuccessfully
 		// escaped string results in a valid number
@@ -274,40 +527,116 @@ func inNumOrStrState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
 	}
 
 	// doesn't look like an number, transition
+	l.trackTermParens(next)
+	l.notePattern(next, false)
 	l.buf += string(next)
 	return inStrState, true
 }
 
 func inStrState(l *queryStringLex, next rune, eof bool) (lexState, bool) {
-	// end on non-escaped space, colon, tilde, boost (or eof)
-	if eof || (!l.inEscape && (next == ' ' || next == ':' || next == '^' || next == '~')) {
-		// end string
+	// end on non-escaped space, colon, tilde, boost, group or range close (or eof)
+	if eof || (!l.inEscape && l.endsTerm(next)) {
+		consumed := eof || next == ' '
+
+		if keyword, ok := l.keyword(next, eof); ok {
+			l.nextTokenType = keyword
+			l.nextToken = &yySymType{}
+			l.logDebugTokensf("%s", l.buf)
+			l.reset()
+			return startState, consumed
+		}
+
+		// end string, which is literal when the only
+		// pattern syntax in it was escaped
 		l.nextTokenType = tSTRING
 		l.nextToken = &yySymType{
-			s: l.buf,
+			s:   l.buf,
+			lit: l.escapedPattern && !l.pattern,
 		}
 		l.logDebugTokensf("STRING - '%s'", l.nextToken.s)
 		l.reset()
 
-		consumed := true
-		if !eof && (next == ':' || next == '^' || next == '~') {
-			consumed = false
-		}
-
 		return startState, consumed
 	} else if !l.inEscape && next == '\\' {
 		l.inEscape = true
 	} else if l.inEscape {
 		// if in escape, end it
 		l.inEscape = false
+		l.notePattern(next, true)
 		l.buf += unescape(string(next))
 	} else {
+		l.trackTermParens(next)
+		l.notePattern(next, false)
 		l.buf += string(next)
 	}
 
 	return inStrState, true
 }
 
+// keyword checks if the string just ended is TO inside a range,
+// or one of the keywordOperators, a field name or field value never is,
+// nor is a string starting with an escape
+func (l *queryStringLex) keyword(next rune, eof bool) (int, bool) {
+	if l.escaped {
+		return 0, false
+	}
+	if l.inRange {
+		return tTO, l.buf == "TO"
+	}
+	if !l.keywords || l.lastTokenType == tCOLON || (!eof && next == ':') {
+		return 0, false
+	}
+	keyword, ok := keywordOperators[l.buf]
+	return keyword, ok
+}
+
+// endsTerm reports whether an unescaped next ends the current
+// string or number
+func (l *queryStringLex) endsTerm(next rune) bool {
+	switch next {
+	case ' ', ':', '^', '~':
+		return true
+	case ']', '}':
+		return l.inRange
+	}
+	return l.endsGroup(next)
+}
+
+// endsGroup reports whether next closes the enclosing group,
+// parens opened inside the current term are kept as part of it
+func (l *queryStringLex) endsGroup(next rune) bool {
+	return next == ')' && l.parenDepth > 0 && l.termParens == 0
+}
+
+// notePattern tracks whether the term has wildcards, or starts like a
+// regular expression, and whether any such characters were escaped
+func (l *queryStringLex) notePattern(next rune, escaped bool) {
+	if next == '*' || next == '?' || (next == '/' && l.buf == "") {
+		if escaped {
+			l.escapedPattern = true
+		} else {
+			l.pattern = true
+		}
+	}
+}
+
+// inFieldGroup reports whether the current term is in a group
+// written after a field, fieldGroups has the depth of each
+func (l *queryStringLex) inFieldGroup() bool {
+	return len(l.fieldGroups) > 0
+}
+
+func (l *queryStringLex) trackTermParens(next rune) {
+	switch next {
+	case '(':
+		l.termParens++
+	case ')':
+		if l.termParens > 0 {
+			l.termParens--
+		}
+	}
+}
+
 func (l *queryStringLex) logDebugTokensf(format string, v ...interface{}) {
 	if l.debugLexer {
 		l.logger.Printf(format, v...)
@@ -1,9 +1,11 @@
 package querystr
 
 import (
+	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/quick"
 )
 
 func TestLexer(t *testing.T) {
@@ -457,6 +459,29 @@ func TestLexer(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: "watex~2^3",
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "watex",
+					},
+				},
+				{
+					typ: tTILDE,
+					lval: yySymType{
+						s: "2",
+					},
+				},
+				{
+					typ: tBOOST,
+					lval: yySymType{
+						s: "3",
+					},
+				},
+			},
+		},
 		{
 			input: "watex~ 2",
 			tokens: []token{
@@ -1198,6 +1223,396 @@ func TestLexer(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: `(a^2)`,
+			tokens: []token{
+				{
+					typ: tLEFTPAREN,
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "a",
+					},
+				},
+				{
+					typ: tBOOST,
+					lval: yySymType{
+						s: "2",
+					},
+				},
+				{
+					typ: tRIGHTPAREN,
+				},
+			},
+		},
+		{
+			input: `(f(x) 5)`,
+			tokens: []token{
+				{
+					typ: tLEFTPAREN,
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "f(x)",
+					},
+				},
+				{
+					typ: tNUMBER,
+					lval: yySymType{
+						s: "5",
+					},
+				},
+				{
+					typ: tRIGHTPAREN,
+				},
+			},
+		},
+		{
+			input: `a)`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "a)",
+					},
+				},
+			},
+		},
+		{
+			input: `a AND b || !c`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "a",
+					},
+				},
+				{
+					typ: tAND,
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "b",
+					},
+				},
+				{
+					typ: tOR,
+				},
+				{
+					typ: tNOT,
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "c",
+					},
+				},
+			},
+		},
+		{
+			input: `OR:AND a&b`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "OR",
+					},
+				},
+				{
+					typ: tCOLON,
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "AND",
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "a&b",
+					},
+				},
+			},
+		},
+		{
+			input: `& |x`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "&",
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "|x",
+					},
+				},
+			},
+		},
+		{
+			input: `age:[10 TO *}`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "age",
+					},
+				},
+				{
+					typ: tCOLON,
+				},
+				{
+					typ: tLEFTBRACKET,
+				},
+				{
+					typ: tNUMBER,
+					lval: yySymType{
+						s: "10",
+					},
+				},
+				{
+					typ: tTO,
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "*",
+					},
+				},
+				{
+					typ: tRIGHTBRACE,
+				},
+			},
+		},
+		{
+			input: `[TO]`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "[TO]",
+					},
+				},
+			},
+		},
+		// an escape at the start of a term keeps it from
+		// being read as a number or keyword
+		{
+			input: `\5 \AND`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "5",
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "AND",
+					},
+				},
+			},
+		},
+		// a minimum should match directly follows a group,
+		// elsewhere @ is part of a term
+		{
+			input: `(a)@75%^2 (b) @3 a@b`,
+			tokens: []token{
+				{
+					typ: tLEFTPAREN,
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "a",
+					},
+				},
+				{
+					typ: tRIGHTPAREN,
+				},
+				{
+					typ: tMINSHOULD,
+					lval: yySymType{
+						s: "75%",
+					},
+				},
+				{
+					typ: tBOOST,
+					lval: yySymType{
+						s: "2",
+					},
+				},
+				{
+					typ: tLEFTPAREN,
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "b",
+					},
+				},
+				{
+					typ: tRIGHTPAREN,
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "@3",
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "a@b",
+					},
+				},
+			},
+		},
+		// strings are literal when their only
+		// wildcard or regexp syntax is escaped
+		{
+			input: `\* a\?b a\?b* /x\/y/ \/z/`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s:   "*",
+						lit: true,
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s:   "a?b",
+						lit: true,
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "a?b*",
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "/x/y/",
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s:   "/z/",
+						lit: true,
+					},
+				},
+			},
+		},
+		// ranges may start anywhere in a group written after a field
+		{
+			input: `f:({1 TO 2]) [x`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "f",
+					},
+				},
+				{
+					typ: tCOLON,
+				},
+				{
+					typ: tLEFTPAREN,
+				},
+				{
+					typ: tLEFTBRACE,
+				},
+				{
+					typ: tNUMBER,
+					lval: yySymType{
+						s: "1",
+					},
+				},
+				{
+					typ: tTO,
+				},
+				{
+					typ: tNUMBER,
+					lval: yySymType{
+						s: "2",
+					},
+				},
+				{
+					typ: tRIGHTBRACKET,
+				},
+				{
+					typ: tRIGHTPAREN,
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "[x",
+					},
+				},
+			},
+		},
+		{
+			input: `\d a\d \1a \AND`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: `\d`,
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: `a\d`,
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "1a",
+					},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "AND",
+					},
+				},
+			},
+		},
+		{
+			input: `x:\d`,
+			tokens: []token{
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: "x",
+					},
+				},
+				{
+					typ:  tCOLON,
+					lval: yySymType{},
+				},
+				{
+					typ: tSTRING,
+					lval: yySymType{
+						s: `\d`,
+					},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -1227,4 +1642,67 @@ func TestLexer(t *testing.T) {
 type token struct {
 	typ int
 	lval yySymType
-}
\ No newline at end of file
+}
+
+// queryText generates strings made up of the pieces
+// most likely to be mistaken for query string syntax
+type queryText string
+
+var queryTextPieces = []string{
+	"AND", "OR", "NOT", "TO", "&&", "||", "0", "42", "3.14", ".", "a", "Z",
+	"é", "日本", " ", "\t", "\n", "\\e", "\x00",
+}
+
+func (queryText) Generate(r *rand.Rand, size int) reflect.Value {
+	var sb strings.Builder
+	for i := r.Intn(size + 1); i >= 0; i-- {
+		if r.Intn(2) == 0 {
+			sb.WriteByte(reservedChars[r.Intn(len(reservedChars))])
+		} else {
+			sb.WriteString(queryTextPieces[r.Intn(len(queryTextPieces))])
+		}
+	}
+	return reflect.ValueOf(queryText(sb.String()))
+}
+
+func lexTokens(input string) []token {
+	l := newQueryStringLex(strings.NewReader(input), DefaultOptions())
+	var tokens []token
+	var lval yySymType
+	for rv := l.Lex(&lval); rv > 0; rv = l.Lex(&lval) {
+		tokens = append(tokens, token{typ: rv, lval: yySymType{s: lval.s}})
+		lval = yySymType{}
+	}
+	return tokens
+}
+
+func TestEscapeLexesAsOneString(t *testing.T) {
+	property := func(text queryText) bool {
+		expected := []token{{typ: tSTRING, lval: yySymType{s: string(text)}}}
+		return reflect.DeepEqual(lexTokens(Escape(string(text))), expected)
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+	// any string at all, except the empty one
+	if err := quick.Check(func(text string) bool {
+		return text == "" || property(queryText(text))
+	}, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuotePhraseLexesAsOnePhrase(t *testing.T) {
+	property := func(text queryText) bool {
+		expected := []token{{typ: tPHRASE, lval: yySymType{s: string(text)}}}
+		return reflect.DeepEqual(lexTokens(QuotePhrase(string(text))), expected)
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+	if err := quick.Check(func(text string) bool {
+		return property(queryText(text))
+	}, nil); err != nil {
+		t.Error(err)
+	}
+}
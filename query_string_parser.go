@@ -17,6 +17,7 @@
 
 //go:generate goyacc -o query_string.y.go query_string.y
 //go:generate sed -i.tmp -e 1d query_string.y.go
+//go:generate sed -i.tmp -e "s/expected := make(\[\]int, 0, 4)/expected := make([]int, 0, 16)/" query_string.y.go
 //go:generate rm query_string.y.go.tmp
 //go:generate gofmt -s -w query_string.y.go
 
@@ -29,28 +30,115 @@ package querystr
 import (
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/blugelabs/bluge"
 	"github.com/blugelabs/bluge/analysis"
 )
 
 type QueryStringOptions struct {
-	debugParser     bool
-	debugLexer      bool
-	debugAnalyzer   bool
-	dateFormat      string
-	logger          *log.Logger
-	analyzers       map[string]*analysis.Analyzer
-	defaultAnalyzer *analysis.Analyzer
+	debugParser      bool
+	debugLexer       bool
+	debugAnalyzer    bool
+	keywordOperators bool
+	lenient          bool
+	dateFormat       string
+	logger           *log.Logger
+	analyzers        map[string]*analysis.Analyzer
+	defaultAnalyzer  *analysis.Analyzer
+	fieldTypes       map[string]FieldType
+	defaultFields    []boostedField
+	defaultOperator  Operator
+	minShould        string
+	existsQuery      func(field string) (bluge.Query, error)
+	emptyMatchesAll  bool
+	negativeGroups   bool
+	fieldPatterns    func(pattern string) []string
+	fieldAliases     map[string][]string
+	fieldMapping     func(field string) []string
+	allowedFields    map[string]bool
+	deniedFields     map[string]bool
+	fieldFilter      func(field string) bool
+	disabled         Feature
+	disabledLiteral  bool
+	maxLength        int
+	maxClauses       int
+	maxDepth         int
+	maxPhraseTerms   int
+	maxFuzziness     int
+}
+
+// Operator is how clauses written without a + or - prefix are combined
+type Operator int
+
+const (
+	// OperatorOr makes unprefixed clauses optional, although unless
+	// there are also required clauses at least one of them has to match
+	OperatorOr Operator = iota
+	// OperatorAnd makes unprefixed clauses required, optional clauses
+	// can still be written with OR
+	OperatorAnd
+)
+
+// Feature is a kind of query syntax which can be disabled, for
+// syntax that is costly to search with or to let callers change scoring
+type Feature int
+
+const (
+	// FeatureRegexp is /regular expression/ terms
+	FeatureRegexp Feature = 1 << iota
+	// FeatureWildcard is terms with * or ? wildcards,
+	// disabling it also disables leading wildcards
+	FeatureWildcard
+	// FeatureLeadingWildcard is terms starting with a wildcard
+	FeatureLeadingWildcard
+	// FeatureFuzzy is term~ fuzzy terms
+	FeatureFuzzy
+	// FeatureRange is ranges, in brackets or written with > and <
+	FeatureRange
+	// FeatureBoost is ^ boosts
+	FeatureBoost
+	// FeatureExists is _exists_ and _missing_ fields, and
+	// [* TO *] ranges, which match any value in a field
+	FeatureExists
+)
+
+var featureNames = map[Feature]string{
+	FeatureRegexp:          "regular expressions",
+	FeatureWildcard:        "wildcards",
+	FeatureLeadingWildcard: "leading wildcards",
+	FeatureFuzzy:           "fuzzy terms",
+	FeatureRange:           "ranges",
+	FeatureBoost:           "boosts",
+	FeatureExists:          "exists queries",
+}
+
+func (f Feature) String() string {
+	var names []string
+	for feature := FeatureRegexp; feature <= FeatureExists; feature <<= 1 {
+		if f&feature != 0 {
+			names = append(names, featureNames[feature])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+type boostedField struct {
+	name  string
+	boost float64
 }
 
 func DefaultOptions() QueryStringOptions {
 	return QueryStringOptions{
-		dateFormat: time.RFC3339,
-		analyzers:  make(map[string]*analysis.Analyzer),
+		keywordOperators: true,
+		dateFormat:       time.RFC3339,
+		analyzers:        make(map[string]*analysis.Analyzer),
+		fieldTypes:       make(map[string]FieldType),
+		fieldAliases:     make(map[string][]string),
 	}
 }
 
@@ -69,6 +157,24 @@ func (o QueryStringOptions) WithDebugAnalyzer(debug bool) QueryStringOptions {
 	return o
 }
 
+// WithKeywordOperators controls whether the words AND, OR and NOT
+// are treated as boolean operators, when disabled they are searched
+// for like any other term. The symbols &&, || and ! are not affected.
+func (o QueryStringOptions) WithKeywordOperators(enabled bool) QueryStringOptions {
+	o.keywordOperators = enabled
+	return o
+}
+
+// WithLenient controls whether parts of the query string which cannot
+// be parsed are searched for as literal text, instead of failing,
+// use ParseQueryStringWithWarnings to find out which parts were.
+// When problems keep turning up the whole query string is searched
+// for as text.
+func (o QueryStringOptions) WithLenient(lenient bool) QueryStringOptions {
+	o.lenient = lenient
+	return o
+}
+
 func (o QueryStringOptions) WithDateFormat(dateFormat string) QueryStringOptions {
 	o.dateFormat = dateFormat
 	return o
@@ -84,29 +190,347 @@ func (o QueryStringOptions) WithAnalyzerForField(field string, analyzer *analysi
 	return o
 }
 
+// WithFieldType declares the type of values indexed in a field,
+// so that only queries suited to that type are built for it
+func (o QueryStringOptions) WithFieldType(field string, typ FieldType) QueryStringOptions {
+	o.fieldTypes[field] = typ
+	return o
+}
+
 func (o QueryStringOptions) WithDefaultAnalyzer(analyzer *analysis.Analyzer) QueryStringOptions {
 	o.defaultAnalyzer = analyzer
 	return o
 }
 
+// WithDefaultOperator sets how clauses written without a + or - prefix,
+// inside groups as well as at the top level, are combined, the default
+// being OperatorOr
+func (o QueryStringOptions) WithDefaultOperator(op Operator) QueryStringOptions {
+	o.defaultOperator = op
+	return o
+}
+
+// WithMinShouldMatch sets how many of the optional clauses of the query,
+// and of each group in it without its own @ suffix, have to match. The
+// value is a count like 3, or a percentage like 75%, either of which
+// may be negative to give how many need not match. Conditions like
+// 3<75% only apply when there are more optional clauses than the number
+// before <, otherwise all are required, several conditions may be given
+// separated by spaces, in ascending order.
+func (o QueryStringOptions) WithMinShouldMatch(spec string) QueryStringOptions {
+	o.minShould = spec
+	return o
+}
+
+// WithEmptyMatchesAll controls whether a query string without any
+// clauses matches every document, rather than none
+func (o QueryStringOptions) WithEmptyMatchesAll(matchAll bool) QueryStringOptions {
+	o.emptyMatchesAll = matchAll
+	return o
+}
+
+// WithNegativeGroupsMatchAll controls whether groups, and chains of
+// operators, with only clauses that must not match, match every other
+// document, as the query as a whole always does, rather than none
+func (o QueryStringOptions) WithNegativeGroupsMatchAll(matchAll bool) QueryStringOptions {
+	o.negativeGroups = matchAll
+	return o
+}
+
+// WithDisabledFeatures sets the kinds of syntax that are not allowed,
+// replacing any set before, query strings using them fail to parse
+// unless searched for as literal text, see WithDisabledFeaturesAsLiteral
+func (o QueryStringOptions) WithDisabledFeatures(features Feature) QueryStringOptions {
+	o.disabled = features
+	return o
+}
+
+// WithDisabledFeaturesAsLiteral controls whether syntax which has been
+// disabled is searched for as literal text, as it is when lenient,
+// instead of failing, boosts are left out rather than made literal.
+// Only the value is made literal, it is still searched for in its field.
+// Use ParseQueryStringWithWarnings to find out which parts were.
+func (o QueryStringOptions) WithDisabledFeaturesAsLiteral(literal bool) QueryStringOptions {
+	o.disabledLiteral = literal
+	return o
+}
+
+// WithMaxLength limits the length of the query string in bytes,
+// none of the limits are checked when set to zero, the default
+func (o QueryStringOptions) WithMaxLength(length int) QueryStringOptions {
+	o.maxLength = length
+	return o
+}
+
+// WithMaxClauses limits how many queries for a term, phrase, range
+// or other clause the built query may have, after expanding aliases,
+// field patterns and default fields, groups are not counted
+func (o QueryStringOptions) WithMaxClauses(clauses int) QueryStringOptions {
+	o.maxClauses = clauses
+	return o
+}
+
+// WithMaxDepth limits how deeply groups may be nested, each group,
+// and each chain of AND or OR operators, is a level of its own
+func (o QueryStringOptions) WithMaxDepth(depth int) QueryStringOptions {
+	o.maxDepth = depth
+	return o
+}
+
+// WithMaxPhraseTerms limits how many words a phrase may have
+func (o QueryStringOptions) WithMaxPhraseTerms(terms int) QueryStringOptions {
+	o.maxPhraseTerms = terms
+	return o
+}
+
+// WithMaxFuzziness limits the edit distance of fuzzy terms
+func (o QueryStringOptions) WithMaxFuzziness(fuzziness int) QueryStringOptions {
+	o.maxFuzziness = fuzziness
+	return o
+}
+
+// WithExistsQuery sets the function building the query for documents with
+// a value in a field, by default any term in the field is matched
+func (o QueryStringOptions) WithExistsQuery(fn func(field string) (bluge.Query, error)) QueryStringOptions {
+	o.existsQuery = fn
+	return o
+}
+
+// WithFieldAlias makes a field name in the query string stand for
+// one or more fields in the index, searching any of them
+func (o QueryStringOptions) WithFieldAlias(alias string, fields ...string) QueryStringOptions {
+	o.fieldAliases[alias] = fields
+	return o
+}
+
+// WithFieldMapping sets a function returning the fields in the index a
+// field name in the query string stands for, or nil for the field itself,
+// it is used for field names without an alias
+func (o QueryStringOptions) WithFieldMapping(fn func(field string) []string) QueryStringOptions {
+	o.fieldMapping = fn
+	return o
+}
+
+// WithAllowedFields restricts the fields which may be searched to those
+// given, as written in the query string, before expanding aliases.
+// Field patterns like title.* may be given. Searching any other field
+// is an error, even when lenient.
+func (o QueryStringOptions) WithAllowedFields(fields ...string) QueryStringOptions {
+	o.allowedFields = make(map[string]bool, len(fields))
+	for _, field := range fields {
+		o.allowedFields[field] = true
+	}
+	return o
+}
+
+// WithDeniedFields keeps the fields given from being searched, whether
+// written in the query string, matched by a field pattern, or stood for
+// by an alias, mapping or default field. Field patterns like title.*
+// may be given.
+func (o QueryStringOptions) WithDeniedFields(fields ...string) QueryStringOptions {
+	o.deniedFields = make(map[string]bool, len(fields))
+	for _, field := range fields {
+		o.deniedFields[field] = true
+	}
+	return o
+}
+
+// WithFieldFilter sets a function deciding which fields may be
+// searched, in addition to any allowed or denied fields, it is
+// given the fields in the index as well as those written
+func (o QueryStringOptions) WithFieldFilter(fn func(field string) bool) QueryStringOptions {
+	o.fieldFilter = fn
+	return o
+}
+
+// WithFieldPatterns sets the function listing the fields matched by a
+// field name with * or ? wildcards, like title.*, by default they are
+// matched against the fields declared with WithFieldType
+func (o QueryStringOptions) WithFieldPatterns(fn func(pattern string) []string) QueryStringOptions {
+	o.fieldPatterns = fn
+	return o
+}
+
+// WithDefaultFields sets the fields searched by terms written without a
+// field, each of which then matches any of them. A field may be followed
+// by ^ and a boost, like title^3, and may be a pattern, like title.*.
+// Without default fields terms search the default field of the index.
+func (o QueryStringOptions) WithDefaultFields(fields ...string) QueryStringOptions {
+	o.defaultFields = make([]boostedField, len(fields))
+	for i, field := range fields {
+		o.defaultFields[i] = boostedField{name: field, boost: noBoost}
+		if j := strings.LastIndexByte(field, '^'); j >= 0 {
+			if boost, err := strconv.ParseFloat(field[j+1:], 64); err == nil {
+				o.defaultFields[i] = boostedField{name: field[:j], boost: boost}
+			}
+		}
+	}
+	return o
+}
+
+func init() {
+	// verbose messages name the unexpected and expected tokens
+	yyErrorVerbose = true
+}
+
+// ParseQueryString parses the query string into a bluge.Query, any
+// problems found are returned as ParseErrors
 func ParseQueryString(query string, options QueryStringOptions) (rq bluge.Query, err error) {
-	if query == "" {
-		return bluge.NewMatchNoneQuery(), nil
+	rq, _, err = ParseQueryStringWithWarnings(query, options)
+	return rq, err
+}
+
+// ParseQueryStringWithWarnings parses the query string like ParseQueryString,
+// when lenient it also returns a Warning for each part of the query string
+// that was searched for as literal text
+func ParseQueryStringWithWarnings(query string, options QueryStringOptions) (bluge.Query, []*Warning, error) {
+	if err := checkLength(query, options); err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimSpace(query) == "" {
+		if options.emptyMatchesAll {
+			return bluge.NewMatchAllQuery(), nil, nil
+		}
+		return bluge.NewMatchNoneQuery(), nil, nil
+	}
+	_, rv, warnings, err := parse(query, options, true)
+	return rv, warnings, err
+}
+
+// ParseAST parses the query string into its syntax tree, without building
+// the bluge.Query, so problems with values that depend on the schema are
+// only found by Compile
+func ParseAST(query string, options QueryStringOptions) (*BooleanNode, error) {
+	root, _, err := ParseASTWithWarnings(query, options)
+	return root, err
+}
+
+// ParseASTWithWarnings parses the query string like ParseAST, when lenient
+// it also returns a Warning for each part of the query string that was
+// parsed as literal text
+func ParseASTWithWarnings(query string, options QueryStringOptions) (*BooleanNode, []*Warning, error) {
+	if err := checkLength(query, options); err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimSpace(query) == "" {
+		root := &BooleanNode{Span: Span{End: len(query)}}
+		if options.emptyMatchesAll {
+			root.Clauses = []*Clause{{Occur: OccurMust, Node: &MatchAllNode{Span: root.Span}}}
+		}
+		return root, nil, nil
+	}
+	root, _, warnings, err := parse(query, options, false)
+	return root, warnings, err
+}
+
+// parse builds the syntax tree for the query string, and compiles
+// it when asked to, when lenient the text blamed for any problems
+// is made literal until there are none left
+func parse(query string, options QueryStringOptions, compile bool) (*BooleanNode, bluge.Query, []*Warning, error) {
+	var warnings []*Warning
+	for pass := 1; ; pass++ {
+		lex := newLexerWrapper(newQueryStringLex(strings.NewReader(query), options), options)
+		lex.lex.literals = literalSpans(warnings)
+		doParse(lex)
+
+		var rv bluge.Query
+		errs := lex.errs
+		if lex.root != nil {
+			lex.root.Span = Span{Start: 0, End: len(query)}
+			c := newCompiler(options)
+			if compile {
+				rv = c.compileRoot(lex.root)
+			} else {
+				c.checkClauses(lex.root)
+			}
+			errs = append(errs, c.errs...)
+		}
+
+		if len(errs) == 0 {
+			return lex.root, rv, ignoredWarnings(query, warnings, lex.ignored), nil
+		}
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Offset < errs[j].Offset
+		})
+		locateErrors(query, errs)
+		if !literalErrors(errs, warnings, options) {
+			return nil, nil, nil, ParseErrors(errs)
+		}
+		if pass == lenientPasses {
+			// problems only found once others are made literal
+			// could take a pass each, rather than carry on the
+			// whole query string is searched for as text
+			warnings = literalQuery(query, errs[0])
+			continue
+		}
+		before, added := warnings, false
+		for _, e := range errs {
+			if literalSince(before, warnings, e) {
+				continue
+			}
+			var ok bool
+			if warnings, ok = addLiteralWarning(query, warnings, e); ok {
+				added = true
+			} else if !added {
+				return nil, nil, nil, ParseErrors(errs)
+			}
+			// otherwise all the text around the error was made
+			// literal in this pass, which may be its cause, so
+			// it is left to the next pass
+		}
 	}
-	lex := newLexerWrapper(newQueryStringLex(strings.NewReader(query), options), options)
-	doParse(lex)
+}
 
-	if len(lex.errs) > 0 {
-		return nil, fmt.Errorf(strings.Join(lex.errs, "\n"))
+// checkLength returns an error if the query string is over the length limit
+func checkLength(query string, options QueryStringOptions) error {
+	if options.maxLength <= 0 || len(query) <= options.maxLength {
+		return nil
 	}
-	return lex.query, nil
+	start := options.maxLength
+	for !utf8.RuneStart(query[start]) {
+		start--
+	}
+	err := errorAt(limitErrorf("query string is longer than %d bytes", options.maxLength),
+		Span{Start: start, End: start})
+	err.locate(query)
+	return ParseErrors{err}
+}
+
+// lenientPasses is how many times the query string is parsed when
+// lenient before giving up on finding each problem in it, syntax
+// errors close together may only be found one pass at a time
+const lenientPasses = 8
+
+// literalErrors reports whether the text blamed for the errors may be
+// searched for as literal text, going over a limit or searching a field
+// which may not be never is, a value already made literal which cannot
+// be searched for in its field is made literal along with the field
+func literalErrors(errs []*ParseError, warnings []*Warning, options QueryStringOptions) bool {
+	for _, e := range errs {
+		switch {
+		case e.Code == ErrorCodeLimit, e.Code == ErrorCodeFieldNotAllowed:
+			return false
+		case options.lenient:
+		case e.Code == ErrorCodeInvalidValue && literalOverlaps(warnings, e.Offset, e.end):
+		case e.Code != ErrorCodeDisabled || !options.disabledLiteral:
+			return false
+		}
+	}
+	return true
 }
 
 func doParse(lex *lexerWrapper) {
 	defer func() {
 		r := recover()
-		if r != nil {
-			lex.errs = append(lex.errs, fmt.Sprintf("parse error: %v", r))
+		if pe, ok := r.(*ParseError); ok {
+			lex.errs = append(lex.errs, pe)
+		} else if r != nil {
+			lex.errs = append(lex.errs, &ParseError{
+				Code:    ErrorCodeSyntax,
+				Message: fmt.Sprintf("parse error: %v", r),
+				Offset:  lex.lex.tokenStart,
+				end:     lex.lex.tokenEnd,
+			})
 		}
 	}()
 
@@ -114,38 +538,100 @@ func doParse(lex *lexerWrapper) {
 }
 
 const (
-	queryShould = iota
-	queryMust
-	queryMustNot
+	queryNoOp = iota
+	queryAnd
+	queryOr
 )
 
+// queryStringClause is a clause being built by the grammar, op
+// records the boolean operator that produced its node, if any
+type queryStringClause struct {
+	*Clause
+	op int
+}
+
+func queryStringAddClause(bn *BooleanNode, c *queryStringClause) *BooleanNode {
+	bn.Clauses = append(bn.Clauses, c.Clause)
+	return bn
+}
+
+// queryStringCombineClauses joins two clauses with a boolean operator,
+// chains of the same operator share a single boolean node, which
+// occurs like a clause without a prefix
+func queryStringCombineClauses(op int, occur Occur, lhs, rhs *queryStringClause) *queryStringClause {
+	if lhs.op != op {
+		bn := &BooleanNode{Span: lhs.Node.Position()}
+		queryStringAddOperand(op, bn, lhs)
+		lhs = &queryStringClause{Clause: &Clause{Occur: occur, Node: bn}, op: op}
+	}
+	bn := lhs.Node.(*BooleanNode)
+	queryStringAddOperand(op, bn, rhs)
+	bn.End = rhs.Node.Position().End
+	return lhs
+}
+
+func queryStringAddOperand(op int, bn *BooleanNode, c *queryStringClause) {
+	occur := c.Occur
+	if occur != OccurMustNot {
+		occur = OccurShould
+		if op == queryAnd {
+			occur = OccurMust
+		}
+	}
+	bn.Clauses = append(bn.Clauses, &Clause{Occur: occur, Node: c.Node, Boost: c.Boost})
+}
+
+func queryStringNegateClause(c *queryStringClause) *queryStringClause {
+	occur := OccurMustNot
+	if c.Occur == OccurMustNot {
+		occur = OccurMust
+	}
+	return &queryStringClause{Clause: &Clause{Occur: occur, Node: c.Node, Boost: c.Boost}}
+}
+
 type lexerWrapper struct {
-	lex         yyLexer
-	errs        []string
-	query       *bluge.BooleanQuery
+	lex         *queryStringLex
+	errs        []*ParseError
+	ignored     []*ParseError
+	root        *BooleanNode
 	debugParser bool
-	dateFormat  string
 	logger      *log.Logger
 	opt         *QueryStringOptions
 }
 
-func newLexerWrapper(lex yyLexer, options QueryStringOptions) *lexerWrapper {
+func newLexerWrapper(lex *queryStringLex, options QueryStringOptions) *lexerWrapper {
 	return &lexerWrapper{
 		lex:         lex,
-		query:       bluge.NewBooleanQuery(),
 		debugParser: options.debugParser,
-		dateFormat:  options.dateFormat,
 		logger:      options.logger,
 		opt:         &options,
 	}
 }
 
 func (l *lexerWrapper) Lex(lval *yySymType) int {
-	return l.lex.Lex(lval)
+	rv := l.lex.Lex(lval)
+	lval.pos, lval.end = l.lex.tokenStart, l.lex.tokenEnd
+	return rv
 }
 
 func (l *lexerWrapper) Error(s string) {
-	l.errs = append(l.errs, s)
+	pe := syntaxError(s)
+	pe.Offset, pe.end = l.lex.tokenStart, l.lex.tokenEnd
+	l.errs = append(l.errs, pe)
+}
+
+// reportError records a problem with the input between
+// start and end, parsing carries on to find any others
+func (l *lexerWrapper) reportError(err error, start, end int) {
+	l.errs = append(l.errs, errorAt(err, Span{Start: start, End: end}))
+}
+
+// defaultOccur is how clauses written without a prefix occur
+func (l *lexerWrapper) defaultOccur() Occur {
+	if l.opt.defaultOperator == OperatorAnd {
+		return OccurMust
+	}
+	return OccurShould
 }
 
 func (l *lexerWrapper) logDebugGrammarf(format string, v ...interface{}) {
@@ -154,98 +640,376 @@ func (l *lexerWrapper) logDebugGrammarf(format string, v ...interface{}) {
 	}
 }
 
-func (l *lexerWrapper) logDebugAnalyzerf(format string, v ...interface{}) {
-	if l.opt.debugAnalyzer {
-		l.logger.Printf(format, v...)
+// queryStringFieldGroup makes the nodes in a group written after a
+// field search that field, unless they were written with their own,
+// a * becomes a wildcard so is checked again for disabled syntax
+func queryStringFieldGroup(l *lexerWrapper, field string, bn *BooleanNode) *BooleanNode {
+	for _, c := range bn.Clauses {
+		switch n := c.Node.(type) {
+		case *BooleanNode:
+			queryStringFieldGroup(l, field, n)
+		case *MatchAllNode:
+			c.Node = &WildcardNode{Field: field, Pattern: "*", Span: n.Span}
+			l.checkFeatures(c.Node, n.Span.Start)
+		case *TermNode:
+			if n.Field == "" {
+				n.Field = field
+			}
+		case *NumberNode:
+			if n.Field == "" {
+				n.Field = field
+			}
+		case *PhraseNode:
+			if n.Field == "" {
+				n.Field = field
+			}
+		case *FuzzyNode:
+			if n.Field == "" {
+				n.Field = field
+			}
+		case *WildcardNode:
+			if n.Field == "" {
+				n.Field = field
+			}
+		case *RegexpNode:
+			if n.Field == "" {
+				n.Field = field
+			}
+		case *RangeNode:
+			if n.Field == "" {
+				n.Field = field
+			}
+		}
+	}
+	return bn
+}
+
+// checkRanges reports ranges written without a field,
+// outside of a group written after one
+func (l *lexerWrapper) checkRanges(bn *BooleanNode) {
+	for _, c := range bn.Clauses {
+		switch n := c.Node.(type) {
+		case *BooleanNode:
+			l.checkRanges(n)
+		case *RangeNode:
+			if n.Field == "" {
+				l.reportError(parseErrorf(ErrorCodeSyntax, "syntax error: range without a field"), n.Start, n.End)
+			}
+		}
+	}
+}
+
+// checkBoost reports an error for a boost when boosts are disabled,
+// the boost is dropped instead when disabled syntax is searched
+// for as literal text, leaving the clause searched for as usual
+func (l *lexerWrapper) checkBoost(node Node, boost *float64, start, end int) *float64 {
+	if boost == nil || l.opt.disabled&FeatureBoost == 0 {
+		return boost
+	}
+	if l.opt.disabledLiteral {
+		l.ignored = append(l.ignored, errorAt(disabledError(FeatureBoost), Span{Start: start, End: end}))
+	} else {
+		l.reportError(disabledError(FeatureBoost), node.Position().Start, end)
+	}
+	return nil
+}
+
+// checkLimits reports an error for each phrase or fuzzy term going
+// over its limit, and for the first group going over the depth limit,
+// the limit on clauses is checked by the compiler
+func (l *lexerWrapper) checkLimits(root *BooleanNode) {
+	var tooDeep Node
+	var walk func(bn *BooleanNode, depth int)
+	walk = func(bn *BooleanNode, depth int) {
+		if l.opt.maxDepth > 0 && depth > l.opt.maxDepth && tooDeep == nil {
+			tooDeep = bn
+		}
+		for _, c := range bn.Clauses {
+			if n, ok := c.Node.(*BooleanNode); ok {
+				walk(n, depth+1)
+				continue
+			}
+			switch n := c.Node.(type) {
+			case *PhraseNode:
+				if l.opt.maxPhraseTerms > 0 && len(strings.Fields(n.Phrase)) > l.opt.maxPhraseTerms {
+					l.reportError(limitErrorf("phrase has more than %d terms", l.opt.maxPhraseTerms), n.Start, n.End)
+				}
+			case *FuzzyNode:
+				if l.opt.maxFuzziness > 0 && n.Fuzziness > l.opt.maxFuzziness {
+					l.reportError(limitErrorf("fuzziness is more than %d", l.opt.maxFuzziness), n.Start, n.End)
+				}
+			}
+		}
+	}
+	walk(root, 0)
+	if tooDeep != nil {
+		span := tooDeep.Position()
+		l.reportError(limitErrorf("groups are nested more than %d deep", l.opt.maxDepth), span.Start, span.End)
+	}
+}
+
+func limitErrorf(format string, v ...interface{}) *ParseError {
+	return parseErrorf(ErrorCodeLimit, format, v...)
+}
+
+// checkFeatures reports an error if the node uses disabled syntax,
+// groups are not checked, their clauses are checked as they are parsed.
+// The error is reported from start, where the value after any field
+// begins, so that only the value is made literal.
+func (l *lexerWrapper) checkFeatures(node Node, start int) {
+	var feature Feature
+	switch n := node.(type) {
+	case *RegexpNode:
+		feature = FeatureRegexp
+	case *WildcardNode:
+		feature = FeatureWildcard
+		if l.opt.disabled&feature == 0 && strings.IndexAny(n.Pattern, "*?") == 0 {
+			feature = FeatureLeadingWildcard
+		}
+	case *FuzzyNode:
+		feature = FeatureFuzzy
+	case *RangeNode:
+		feature = FeatureRange
+		if l.opt.disabled&feature == 0 && n.Min == nil && n.Max == nil {
+			feature = FeatureExists
+		}
+	case *ExistsNode:
+		feature = FeatureExists
+	}
+	if l.opt.disabled&feature != 0 {
+		l.reportError(disabledError(feature), start, node.Position().End)
+	}
+}
+
+func disabledError(feature Feature) error {
+	return parseErrorf(ErrorCodeDisabled, "%s are disabled", feature)
+}
+
+// queryStringStringNode decides what kind of node a string is,
+// literal text is always a term
+func queryStringStringNode(field, str string, literal bool, span Span) Node {
+	switch {
+	case literal:
+	case str == "*" && (field == "" || field == "*"):
+		return &MatchAllNode{Span: span}
+	case field == "_exists_" || field == "_missing_":
+		return &ExistsNode{Field: str, Missing: field == "_missing_", Span: span}
+	case len(str) > 1 && strings.HasPrefix(str, "/") && strings.HasSuffix(str, "/"):
+		return &RegexpNode{Field: field, Pattern: str[1 : len(str)-1], Span: span}
+	case strings.ContainsAny(str, "*?"):
+		return &WildcardNode{Field: field, Pattern: str, Span: span}
 	}
+	return &TermNode{Field: field, Term: str, Span: span}
 }
 
-func queryTimeFromString(yylex yyLexer, t string) (time.Time, error) {
-	rv, err := time.Parse(yylex.(*lexerWrapper).dateFormat, t)
+func queryStringParseFuzziness(str string) (int, error) {
+	fuzziness, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, parseErrorf(ErrorCodeInvalidValue, "invalid fuzziness value: %v", err)
+	}
+	return int(fuzziness), nil
+}
+
+func queryStringParseSlop(str string) (int, error) {
+	slop, err := strconv.Atoi(str)
+	if err != nil || slop < 0 {
+		return 0, parseErrorf(ErrorCodeInvalidValue, "invalid slop value: %s", str)
+	}
+	return slop, nil
+}
+
+func queryTimeFromString(c *compiler, t string) (time.Time, error) {
+	rv, err := time.Parse(c.opt.dateFormat, t)
 	if err != nil {
 		return time.Time{}, err
 	}
 	return rv, nil
 }
 
-func queryStringStringToken(yylex yyLexer, field, str string) bluge.Query {
-	if strings.HasPrefix(str, "/") && strings.HasSuffix(str, "/") {
-		return bluge.NewRegexpQuery(str[1 : len(str)-1]).SetField(field)
-	} else if strings.ContainsAny(str, "*?") {
-		return bluge.NewWildcardQuery(str).SetField(field)
+func queryStringStringToken(c *compiler, field, str string) (bluge.Query, error) {
+	if typ := fieldTypeForField(c, field); typ != UntypedField && typ != TextField {
+		return queryStringValue(c, field, typ, str)
 	}
 	rv := bluge.NewMatchQuery(str).SetField(field)
-	analyzer := analyzerForField(yylex, field)
+	analyzer := analyzerForField(c, field)
 	if analyzer != nil {
 		rv.SetAnalyzer(analyzer)
 	}
-	return rv
+	return rv, nil
 }
 
-func queryStringStringTokenFuzzy(yylex yyLexer, field, str, fuzziness string) (*bluge.MatchQuery, error) {
-	fuzzy, err := strconv.ParseFloat(fuzziness, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid fuzziness value: %v", err)
+func queryStringRegexp(c *compiler, field, pattern string) (bluge.Query, error) {
+	if typ := fieldTypeForField(c, field); !typ.searchesText() {
+		return nil, fieldTypeError("regular expression", field, typ)
+	}
+	return bluge.NewRegexpQuery(pattern).SetField(field), nil
+}
+
+func queryStringWildcard(c *compiler, field, pattern string) (bluge.Query, error) {
+	if typ := fieldTypeForField(c, field); !typ.searchesText() {
+		return nil, fieldTypeError("wildcard", field, typ)
+	}
+	return bluge.NewWildcardQuery(pattern).SetField(field), nil
+}
+
+func queryStringStringTokenFuzzy(c *compiler, field, str string, fuzziness int) (bluge.Query, error) {
+	switch typ := fieldTypeForField(c, field); typ {
+	case KeywordField:
+		return bluge.NewFuzzyQuery(str).SetFuzziness(fuzziness).SetField(field), nil
+	case UntypedField, TextField:
+	default:
+		return nil, fieldTypeError("fuzzy", field, typ)
 	}
-	rv := bluge.NewMatchQuery(str).SetFuzziness(int(fuzzy)).SetField(field)
-	analyzer := analyzerForField(yylex, field)
+	rv := bluge.NewMatchQuery(str).SetFuzziness(fuzziness).SetField(field)
+	analyzer := analyzerForField(c, field)
 	if analyzer != nil {
 		rv.SetAnalyzer(analyzer)
 	}
 	return rv, nil
 }
 
-func queryStringNumberToken(yylex yyLexer, field, str string) (bluge.Query, error) {
+func queryStringNumberToken(c *compiler, field, str string) (bluge.Query, error) {
+	typ := fieldTypeForField(c, field)
+	if typ != UntypedField && typ != TextField {
+		return queryStringValue(c, field, typ, str)
+	}
 	q1 := bluge.NewMatchQuery(str).SetField(field)
 	val, err := strconv.ParseFloat(str, 64)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing number: %v", err)
+		return nil, parseErrorf(ErrorCodeInvalidValue, "error parsing number: %v", err)
 	}
-	analyzer := analyzerForField(yylex, field)
+	analyzer := analyzerForField(c, field)
 	if analyzer != nil {
 		q1.SetAnalyzer(analyzer)
 	}
+	if typ == TextField {
+		return q1, nil
+	}
 	q2 := bluge.NewNumericRangeInclusiveQuery(val, val, true, true).SetField(field)
 	return bluge.NewBooleanQuery().AddShould([]bluge.Query{q1, q2}...), nil
 }
 
-func queryStringPhraseToken(field, str string) *bluge.MatchPhraseQuery {
-	return bluge.NewMatchPhraseQuery(str).SetField(field)
+func queryStringPhraseToken(c *compiler, field, str string, slop int) (bluge.Query, error) {
+	if typ := fieldTypeForField(c, field); typ != UntypedField && typ != TextField {
+		if slop != 0 {
+			return nil, fieldTypeError("phrase slop", field, typ)
+		}
+		return queryStringValue(c, field, typ, str)
+	}
+	rv := bluge.NewMatchPhraseQuery(str).SetField(field).SetSlop(slop)
+	analyzer := analyzerForField(c, field)
+	if analyzer != nil {
+		rv.SetAnalyzer(analyzer)
+	}
+	return rv, nil
 }
 
-func queryStringNumericRangeGreaterThanOrEqual(field, str string, orEqual bool) (*bluge.NumericRangeQuery, error) {
-	min, err := strconv.ParseFloat(str, 64)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing number: %v", err)
+func queryStringRange(c *compiler, r *RangeNode) (bluge.Query, error) {
+	minInclusive, maxInclusive := r.MinInclusive, r.MaxInclusive
+	if r.Min == nil && r.Max == nil {
+		// open at both ends any value in the field matches
+		return queryStringExists(c, r.Field, false)
+	} else if r.Min == nil {
+		minInclusive = true
+	} else if r.Max == nil {
+		maxInclusive = true
 	}
-	return bluge.NewNumericRangeInclusiveQuery(min, bluge.MaxNumeric, orEqual, true).
-		SetField(field), nil
+
+	typ := fieldTypeForField(c, r.Field)
+	if typ == UntypedField {
+		var err error
+		typ, err = queryStringRangeType(r.Min, r.Max)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch typ {
+	case NumericField:
+		return queryStringNumericRange(r.Field, r.Min, r.Max, minInclusive, maxInclusive)
+	case DateField:
+		return queryStringDateRange(c, r.Field, r.Min, r.Max, minInclusive, maxInclusive)
+	case TextField, KeywordField:
+		return queryStringTermRange(r.Field, r.Min, r.Max, minInclusive, maxInclusive), nil
+	}
+	return nil, fieldTypeError("range", r.Field, typ)
 }
 
-func queryStringNumericRangeLessThanOrEqual(field, str string, orEqual bool) (*bluge.NumericRangeQuery, error) {
-	max, err := strconv.ParseFloat(str, 64)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing number: %v", err)
+// queryStringRangeType decides what kind of range to build for a field
+// not in the schema, based on how its bounds were written
+func queryStringRangeType(min, max *RangeBound) (FieldType, error) {
+	var kind ValueKind
+	switch {
+	case min == nil:
+		kind = max.Kind
+	case max == nil || min.Kind == max.Kind:
+		kind = min.Kind
+	case min.Kind == PhraseValue || max.Kind == PhraseValue:
+		return UntypedField, parseErrorf(ErrorCodeInvalidRange, "range bounds '%s' and '%s' are of different types", min.Value, max.Value)
+	default:
+		// a number alongside a plain string is compared as a term
+		kind = TermValue
 	}
-	return bluge.NewNumericRangeInclusiveQuery(bluge.MinNumeric, max, true, orEqual).
-		SetField(field), nil
+
+	switch kind {
+	case NumberValue:
+		return NumericField, nil
+	case PhraseValue:
+		return DateField, nil
+	}
+	return KeywordField, nil
 }
 
-func queryStringDateRangeGreaterThanOrEqual(yylex yyLexer, field, phrase string, orEqual bool) (*bluge.DateRangeQuery, error) {
-	minTime, err := queryTimeFromString(yylex, phrase)
-	if err != nil {
-		return nil, fmt.Errorf("invalid time: %v", err)
+func queryStringTermRange(field string, min, max *RangeBound,
+	minInclusive, maxInclusive bool) *bluge.TermRangeQuery {
+	var minTerm, maxTerm string
+	if min != nil {
+		minTerm = min.Value
 	}
-	return bluge.NewDateRangeInclusiveQuery(minTime, time.Time{}, orEqual, true).
+	if max != nil {
+		maxTerm = max.Value
+	}
+	return bluge.NewTermRangeInclusiveQuery(minTerm, maxTerm, minInclusive, maxInclusive).
+		SetField(field)
+}
+
+func queryStringNumericRange(field string, min, max *RangeBound,
+	minInclusive, maxInclusive bool) (bluge.Query, error) {
+	minVal, maxVal := bluge.MinNumeric, bluge.MaxNumeric
+	var err error
+	if min != nil {
+		minVal, err = strconv.ParseFloat(min.Value, 64)
+		if err != nil {
+			return nil, parseErrorf(ErrorCodeInvalidValue, "error parsing number: %v", err)
+		}
+	}
+	if max != nil {
+		maxVal, err = strconv.ParseFloat(max.Value, 64)
+		if err != nil {
+			return nil, parseErrorf(ErrorCodeInvalidValue, "error parsing number: %v", err)
+		}
+	}
+	return bluge.NewNumericRangeInclusiveQuery(minVal, maxVal, minInclusive, maxInclusive).
 		SetField(field), nil
 }
 
-func queryStringDateRangeLessThanOrEqual(yylex yyLexer, field, phrase string, orEqual bool) (*bluge.DateRangeQuery, error) {
-	maxTime, err := queryTimeFromString(yylex, phrase)
-	if err != nil {
-		return nil, fmt.Errorf("invalid time: %v", err)
+func queryStringDateRange(c *compiler, field string, min, max *RangeBound,
+	minInclusive, maxInclusive bool) (bluge.Query, error) {
+	var minTime, maxTime time.Time
+	var err error
+	if min != nil {
+		minTime, err = queryTimeFromString(c, min.Value)
+		if err != nil {
+			return nil, parseErrorf(ErrorCodeInvalidValue, "invalid time: %v", err)
+		}
 	}
-	return bluge.NewDateRangeInclusiveQuery(time.Time{}, maxTime, true, orEqual).
+	if max != nil {
+		maxTime, err = queryTimeFromString(c, max.Value)
+		if err != nil {
+			return nil, parseErrorf(ErrorCodeInvalidValue, "invalid time: %v", err)
+		}
+	}
+	return bluge.NewDateRangeInclusiveQuery(minTime, maxTime, minInclusive, maxInclusive).
 		SetField(field), nil
 }
 
@@ -254,13 +1018,66 @@ const noBoost = 1.0
 func queryStringParseBoost(str string) (float64, error) {
 	boost, err := strconv.ParseFloat(str, 64)
 	if err != nil {
-		return noBoost, fmt.Errorf("invalid boost value: %v", err)
+		return noBoost, parseErrorf(ErrorCodeInvalidValue, "invalid boost value: %v", err)
 	}
 	return boost, nil
 }
 
+// queryStringMinShould works out how many of the optional clauses
+// have to match for a minimum should match value
+func queryStringMinShould(spec string, shoulds int) (int, error) {
+	conditions := strings.Fields(spec)
+	if len(conditions) == 0 {
+		return 0, parseErrorf(ErrorCodeInvalidValue, "invalid minimum should match value '%s'", spec)
+	}
+	rv := shoulds
+	last := -1
+	for _, condition := range conditions {
+		above, value := -1, condition
+		if i := strings.IndexByte(condition, '<'); i >= 0 {
+			n, err := strconv.Atoi(condition[:i])
+			if err != nil || n <= last {
+				return 0, parseErrorf(ErrorCodeInvalidValue, "invalid minimum should match value '%s'", spec)
+			}
+			above, value = n, condition[i+1:]
+		} else if len(conditions) > 1 {
+			return 0, parseErrorf(ErrorCodeInvalidValue, "invalid minimum should match value '%s'", spec)
+		}
+		last = above
+
+		percent := strings.HasSuffix(value, "%")
+		min, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || (percent && (min < -100 || min > 100)) {
+			return 0, parseErrorf(ErrorCodeInvalidValue, "invalid minimum should match value '%s'", spec)
+		}
+		// negative values are how many need not match
+		negative := min < 0
+		if percent {
+			min = min * shoulds / 100
+		}
+		if negative {
+			min += shoulds
+		}
+		if shoulds > above {
+			rv = min
+		}
+	}
+
+	if rv < 0 {
+		return 0, nil
+	}
+	if rv > shoulds {
+		return shoulds, nil
+	}
+	return rv, nil
+}
+
 func queryStringSetBoost(q bluge.Query, b float64) (bluge.Query, error) {
 	switch v := q.(type) {
+	case *bluge.MatchAllQuery:
+		return v.SetBoost(b), nil
+	case *bluge.PrefixQuery:
+		return v.SetBoost(b), nil
 	case *bluge.MatchQuery:
 		return v.SetBoost(b), nil
 	case *bluge.RegexpQuery:
@@ -275,19 +1092,51 @@ func queryStringSetBoost(q bluge.Query, b float64) (bluge.Query, error) {
 		return v.SetBoost(b), nil
 	case *bluge.DateRangeQuery:
 		return v.SetBoost(b), nil
+	case *bluge.TermRangeQuery:
+		return v.SetBoost(b), nil
+	case *bluge.TermQuery:
+		return v.SetBoost(b), nil
+	case *bluge.FuzzyQuery:
+		return v.SetBoost(b), nil
 	}
-	return nil, fmt.Errorf("cannot boost %T", q)
+	return nil, parseErrorf(ErrorCodeUnsupported, "cannot boost %T", q)
+}
+
+// queryStringBoost returns the boost the query already has
+func queryStringBoost(q bluge.Query) float64 {
+	if b, ok := q.(interface{ Boost() float64 }); ok {
+		return b.Boost()
+	}
+	return noBoost
+}
+
+// queryStringExists builds the query for documents with a value in
+// the field, or without one
+func queryStringExists(c *compiler, field string, missing bool) (bluge.Query, error) {
+	var rv bluge.Query = bluge.NewPrefixQuery("").SetField(field)
+	if c.opt.existsQuery != nil {
+		var err error
+		rv, err = c.opt.existsQuery(field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if missing {
+		return bluge.NewBooleanQuery().
+			AddMust(bluge.NewMatchAllQuery()).
+			AddMustNot(rv), nil
+	}
+	return rv, nil
 }
 
-func analyzerForField(yylex yyLexer, field string) *analysis.Analyzer {
-	lw := yylex.(*lexerWrapper)
-	if analyzer, ok := lw.opt.analyzers[field]; ok {
-		lw.logDebugAnalyzerf("specific analyzer used for field '%s'", field)
+func analyzerForField(c *compiler, field string) *analysis.Analyzer {
+	if analyzer, ok := c.opt.analyzers[field]; ok {
+		c.logDebugAnalyzerf("specific analyzer used for field '%s'", field)
 		return analyzer
-	} else if lw.opt.defaultAnalyzer != nil {
-		lw.logDebugAnalyzerf("default analyzer used for field '%s'", field)
-		return lw.opt.defaultAnalyzer
+	} else if c.opt.defaultAnalyzer != nil {
+		c.logDebugAnalyzerf("default analyzer used for field '%s'", field)
+		return c.opt.defaultAnalyzer
 	}
-	lw.logDebugAnalyzerf("no analyzer set for field '%s'", field)
+	c.logDebugAnalyzerf("no analyzer set for field '%s'", field)
 	return nil
 }
@@ -15,7 +15,12 @@
 package querystr
 
 import (
+	"bytes"
+	"errors"
 	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/analysis/analyzer"
+	"log"
+	"math"
 	"reflect"
 	"strings"
 	"testing"
@@ -89,6 +94,7 @@ func TestQuerySyntaxParserValid(t *testing.T) {
 		{
 			input: "-field2:test2",
 			result: bluge.NewBooleanQuery().
+				AddMust(bluge.NewMatchAllQuery()).
 				AddMustNot(bluge.NewMatchQuery("test2").SetField("field2")),
 		},
 		{
@@ -104,6 +110,7 @@ func TestQuerySyntaxParserValid(t *testing.T) {
 		{
 			input: `-field5:"test phrase 2"`,
 			result: bluge.NewBooleanQuery().
+				AddMust(bluge.NewMatchAllQuery()).
 				AddMustNot(bluge.NewMatchPhraseQuery("test phrase 2").SetField("field5")),
 		},
 		{
@@ -378,6 +385,278 @@ func TestQuerySyntaxParserValid(t *testing.T) {
 					AddShould(bluge.NewNumericRangeInclusiveQuery(65, 65, true, true).SetField("age")).
 					SetBoost(10)),
 		},
+		// groups
+		{
+			input: `(a b)`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewMatchQuery("a")).
+					AddShould(bluge.NewMatchQuery("b"))),
+		},
+		{
+			input: `+(title:go title:rust) -status:draft`,
+			result: bluge.NewBooleanQuery().
+				AddMust(bluge.NewBooleanQuery().
+					AddShould(bluge.NewMatchQuery("go").SetField("title")).
+					AddShould(bluge.NewMatchQuery("rust").SetField("title"))).
+				AddMustNot(bluge.NewMatchQuery("draft").SetField("status")),
+		},
+		{
+			input: `(+a (b -c)^2)^3 d`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddMust(bluge.NewMatchQuery("a")).
+					AddShould(bluge.NewBooleanQuery().
+						AddShould(bluge.NewMatchQuery("b")).
+						AddMustNot(bluge.NewMatchQuery("c")).
+						SetBoost(2)).
+					SetBoost(3)).
+				AddShould(bluge.NewMatchQuery("d")),
+		},
+		{
+			input: `(age:65 "a phrase" x^2 y~1)`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewBooleanQuery().
+						AddShould(bluge.NewMatchQuery("65").SetField("age")).
+						AddShould(bluge.NewNumericRangeInclusiveQuery(65, 65, true, true).SetField("age"))).
+					AddShould(bluge.NewMatchPhraseQuery("a phrase")).
+					AddShould(bluge.NewMatchQuery("x").SetBoost(2)).
+					AddShould(bluge.NewMatchQuery("y").SetFuzziness(1))),
+		},
+		// parens inside a term are kept with the term
+		{
+			input: `(name:/ma(r|t)y/ f(x))`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewRegexpQuery("ma(r|t)y").SetField("name")).
+					AddShould(bluge.NewMatchQuery("f(x)"))),
+		},
+		// outside of a group a close paren is just text
+		{
+			input: `smile:)`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery(")").SetField("smile")),
+		},
+		{
+			input: `\(a\)`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("(a)")),
+		},
+		// boolean operators
+		{
+			input: `a AND b`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddMust(bluge.NewMatchQuery("a")).
+					AddMust(bluge.NewMatchQuery("b"))),
+		},
+		{
+			input: `a AND b OR NOT c`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewBooleanQuery().
+						AddMust(bluge.NewMatchQuery("a")).
+						AddMust(bluge.NewMatchQuery("b"))).
+					AddMustNot(bluge.NewMatchQuery("c"))),
+		},
+		{
+			input: `a || b && c && !d`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewMatchQuery("a")).
+					AddShould(bluge.NewBooleanQuery().
+						AddMust(bluge.NewMatchQuery("b")).
+						AddMust(bluge.NewMatchQuery("c")).
+						AddMustNot(bluge.NewMatchQuery("d")))),
+		},
+		{
+			input: `x (a OR b) AND -c`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("x")).
+				AddShould(bluge.NewBooleanQuery().
+					AddMust(bluge.NewBooleanQuery().
+						AddShould(bluge.NewBooleanQuery().
+							AddShould(bluge.NewMatchQuery("a")).
+							AddShould(bluge.NewMatchQuery("b")))).
+					AddMustNot(bluge.NewMatchQuery("c"))),
+		},
+		{
+			input: `NOT NOT a`,
+			result: bluge.NewBooleanQuery().
+				AddMust(bluge.NewMatchQuery("a")),
+		},
+		// keywords are only operators in uppercase
+		{
+			input: `rock and roll`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("rock")).
+				AddShould(bluge.NewMatchQuery("and")).
+				AddShould(bluge.NewMatchQuery("roll")),
+		},
+		// bracketed ranges
+		{
+			input: `age:[18 TO 65]`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewNumericRangeInclusiveQuery(18, 65, true, true).
+					SetField("age")),
+		},
+		{
+			input: `age:{-1.5 TO 65}`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewNumericRangeInclusiveQuery(-1.5, 65, false, false).
+					SetField("age")),
+		},
+		{
+			input: `+age:[18 TO *}^2`,
+			result: bluge.NewBooleanQuery().
+				AddMust(bluge.NewNumericRangeInclusiveQuery(18, bluge.MaxNumeric, true, true).
+					SetField("age").
+					SetBoost(2)),
+		},
+		{
+			input: `age:{* TO 65]`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewNumericRangeInclusiveQuery(bluge.MinNumeric, 65, true, true).
+					SetField("age")),
+		},
+		{
+			input: `field:["2006-01-02T15:04:05Z" TO "2006-01-02T15:04:05Z"}`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewDateRangeInclusiveQuery(theDate, theDate, true, false).
+					SetField("field")),
+		},
+		{
+			input: `(field:{"2006-01-02T15:04:05Z" TO *])`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewDateRangeInclusiveQuery(theDate, time.Time{}, false, true).
+						SetField("field"))),
+		},
+		// brackets not following a field are just text
+		{
+			input: `[link]`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("[link]")),
+		},
+		// term ranges
+		{
+			input: `field:>text`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermRangeInclusiveQuery("text", "", false, true).
+					SetField("field")),
+		},
+		{
+			input: `sku:>=AB100`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermRangeInclusiveQuery("AB100", "", true, true).
+					SetField("sku")),
+		},
+		{
+			input: `field:<text`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermRangeInclusiveQuery("", "text", true, false).
+					SetField("field")),
+		},
+		{
+			input: `field:<=text^2`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermRangeInclusiveQuery("", "text", true, true).
+					SetField("field").
+					SetBoost(2)),
+		},
+		{
+			input: `user:[alice TO bob}`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermRangeInclusiveQuery("alice", "bob", true, false).
+					SetField("user")),
+		},
+		{
+			input: `version:{1.2 TO 1.10b]`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermRangeInclusiveQuery("1.2", "1.10b", false, true).
+					SetField("version")),
+		},
+		{
+			input: `user:{* TO bob}`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermRangeInclusiveQuery("", "bob", true, false).
+					SetField("user")),
+		},
+		// phrase slop
+		{
+			input: `"quick fox"~3`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchPhraseQuery("quick fox").SetSlop(3)),
+		},
+		{
+			input: `+title:"quick fox"~ body:"lazy dog"~0`,
+			result: bluge.NewBooleanQuery().
+				AddMust(bluge.NewMatchPhraseQuery("quick fox").SetSlop(1).SetField("title")).
+				AddShould(bluge.NewMatchPhraseQuery("lazy dog").SetSlop(0).SetField("body")),
+		},
+		{
+			input: `(a b c)@2 (d e)@-25%^3`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewMatchQuery("a")).
+					AddShould(bluge.NewMatchQuery("b")).
+					AddShould(bluge.NewMatchQuery("c")).
+					SetMinShould(2)).
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewMatchQuery("d")).
+					AddShould(bluge.NewMatchQuery("e")).
+					SetMinShould(2).
+					SetBoost(3)),
+		},
+		{
+			input: `_exists_:title -_exists_:body _missing_:tags^2`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewPrefixQuery("").SetField("title")).
+				AddShould(bluge.NewBooleanQuery().
+					AddMust(bluge.NewMatchAllQuery()).
+					AddMustNot(bluge.NewPrefixQuery("").SetField("tags")).
+					SetBoost(2)).
+				AddMustNot(bluge.NewPrefixQuery("").SetField("body")),
+		},
+		{
+			input: `age:[* TO *] name:{* TO *}^2`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewPrefixQuery("").SetField("age")).
+				AddShould(bluge.NewPrefixQuery("").SetField("name").SetBoost(2)),
+		},
+		{
+			input: `* *:*^2 title:* -foo`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchAllQuery()).
+				AddShould(bluge.NewMatchAllQuery().SetBoost(2)).
+				AddShould(bluge.NewWildcardQuery("*").SetField("title")).
+				AddMustNot(bluge.NewMatchQuery("foo")),
+		},
+		{
+			input: `NOT foo`,
+			result: bluge.NewBooleanQuery().
+				AddMust(bluge.NewMatchAllQuery()).
+				AddMustNot(bluge.NewMatchQuery("foo")),
+		},
+		{
+			input: `title:(foo "bar baz"~1 qu?x -old^2 (+/re/ other:x)) price:(>=10 [1 TO 5})@1 tag:(*)`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewMatchQuery("foo").SetField("title")).
+					AddShould(bluge.NewMatchPhraseQuery("bar baz").SetSlop(1).SetField("title")).
+					AddShould(bluge.NewWildcardQuery("qu?x").SetField("title")).
+					AddShould(bluge.NewBooleanQuery().
+						AddMust(bluge.NewRegexpQuery("re").SetField("title")).
+						AddShould(bluge.NewMatchQuery("x").SetField("other"))).
+					AddMustNot(bluge.NewMatchQuery("old").SetField("title").SetBoost(2))).
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewNumericRangeInclusiveQuery(10, bluge.MaxNumeric, true, true).SetField("price")).
+					AddShould(bluge.NewNumericRangeInclusiveQuery(1, 5, true, false).SetField("price")).
+					SetMinShould(1)).
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewWildcardQuery("*").SetField("tag"))),
+		},
 	}
 
 	for _, test := range tests {
@@ -400,10 +679,6 @@ func TestQuerySyntaxParserInvalid(t *testing.T) {
 		{"^5"},
 		{"field:-text"},
 		{"field:+text"},
-		{"field:>text"},
-		{"field:>=text"},
-		{"field:<text"},
-		{"field:<=text"},
 		{"field:~text"},
 		{"field:^text"},
 		{"field::text"},
@@ -418,6 +693,24 @@ func TestQuerySyntaxParserInvalid(t *testing.T) {
 		{`field:>=` + strings.Repeat(`9`, 369)},
 		{`field:<` + strings.Repeat(`9`, 369)},
 		{`field:<=` + strings.Repeat(`9`, 369)},
+		{"(a b"},
+		{"()"},
+		{"a (b"},
+		{"a AND"},
+		{"OR b"},
+		{"a AND OR b"},
+		{"NOT"},
+		{"age:[1 TO 5"},
+		{"age:[1 5]"},
+		{`age:[1 TO "2006-01-02T15:04:05Z"]`},
+		{`field:["yesterday" TO *]`},
+		{`user:[alice TO "2006-01-02T15:04:05Z"]`},
+		{`"quick fox"~1.5`},
+		{`"quick fox"~-1`},
+		{`title:"quick fox"~near`},
+		{">5"},
+		{"(<=5)"},
+		{"title:(a [b)"},
 	}
 
 	for _, test := range tests {
@@ -452,3 +745,1560 @@ func BenchmarkLexer(b *testing.B) {
 	}
 
 }
+
+func TestQuerySyntaxParserKeywordOperatorsDisabled(t *testing.T) {
+	options := DefaultOptions().WithKeywordOperators(false)
+	q, err := ParseQueryString(`black AND white && !grey`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery("black")).
+		AddShould(bluge.NewMatchQuery("AND")).
+		AddShould(bluge.NewBooleanQuery().
+			AddMust(bluge.NewMatchQuery("white")).
+			AddMustNot(bluge.NewMatchQuery("grey")))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+}
+
+func TestQuerySyntaxParserFieldTypes(t *testing.T) {
+	theDate, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := DefaultOptions().
+		WithFieldType("title", TextField).
+		WithFieldType("sku", KeywordField).
+		WithFieldType("price", NumericField).
+		WithFieldType("created", DateField).
+		WithFieldType("active", BooleanField).
+		WithFieldType("location", GeoField)
+
+	tests := []struct {
+		input  string
+		result bluge.Query
+	}{
+		{
+			input: `title:33`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("33").SetField("title")),
+		},
+		{
+			input: `sku:AB100 sku:"AB 100" sku:42`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermQuery("AB100").SetField("sku")).
+				AddShould(bluge.NewTermQuery("AB 100").SetField("sku")).
+				AddShould(bluge.NewTermQuery("42").SetField("sku")),
+		},
+		{
+			input: `sku:AB10~1 sku:AB*`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewFuzzyQuery("AB10").SetFuzziness(1).SetField("sku")).
+				AddShould(bluge.NewWildcardQuery("AB*").SetField("sku")),
+		},
+		{
+			input: `sku:AB100^2`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermQuery("AB100").SetField("sku").SetBoost(2)),
+		},
+		{
+			input: `sku:>42`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermRangeInclusiveQuery("42", "", false, true).SetField("sku")),
+		},
+		{
+			input: `price:42 price:1e3`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewNumericRangeInclusiveQuery(42, 42, true, true).SetField("price")).
+				AddShould(bluge.NewNumericRangeInclusiveQuery(1000, 1000, true, true).SetField("price")),
+		},
+		{
+			input: `price:[1e2 TO *]`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewNumericRangeInclusiveQuery(100, bluge.MaxNumeric, true, true).SetField("price")),
+		},
+		{
+			input: `created:"2006-01-02T15:04:05Z"`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewDateRangeInclusiveQuery(theDate, theDate, true, true).SetField("created")),
+		},
+		{
+			input: `created:<="2006-01-02T15:04:05Z"`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewDateRangeInclusiveQuery(time.Time{}, theDate, true, true).SetField("created")),
+		},
+		{
+			input: `active:TRUE`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewTermQuery("true").SetField("active")),
+		},
+		// fields not in the schema are unaffected
+		{
+			input: `other:42`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewBooleanQuery().
+					AddShould(bluge.NewMatchQuery("42").SetField("other")).
+					AddShould(bluge.NewNumericRangeInclusiveQuery(42, 42, true, true).SetField("other"))),
+		},
+	}
+
+	for _, test := range tests {
+		q, err := ParseQueryString(test.input, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(q, test.result) {
+			t.Errorf("Expected %#v, got %#v: for %s", test.result, q, test.input)
+		}
+	}
+
+	invalid := []string{
+		`price:cheap`,
+		`price:"cheap"`,
+		`price:4*`,
+		`price:/4.*/`,
+		`price:4~1`,
+		`price:>cheap`,
+		`created:yesterday`,
+		`created:[1 TO 5]`,
+		`active:maybe`,
+		`active:[false TO true]`,
+		`location:here`,
+		`location:>5`,
+	}
+	for _, input := range invalid {
+		_, err := ParseQueryString(input, options)
+		if err == nil {
+			t.Errorf("expected error, got nil for `%s`", input)
+		}
+	}
+}
+
+func TestQuerySyntaxParserPhraseSlopAnalyzer(t *testing.T) {
+	keyword := analyzer.NewKeywordAnalyzer()
+	options := DefaultOptions().
+		WithAnalyzerForField("title", keyword).
+		WithFieldType("sku", KeywordField)
+
+	q, err := ParseQueryString(`title:"quick fox"~2`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchPhraseQuery("quick fox").
+			SetSlop(2).
+			SetField("title").
+			SetAnalyzer(keyword))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	_, err = ParseQueryString(`sku:"AB 100"~2`, options)
+	if err == nil {
+		t.Errorf("expected error for phrase slop on keyword field")
+	}
+}
+
+func TestQuerySyntaxParserPhraseAnalyzer(t *testing.T) {
+	keyword := analyzer.NewKeywordAnalyzer()
+	simple := analyzer.NewSimpleAnalyzer()
+	var logged bytes.Buffer
+	options := DefaultOptions().
+		WithAnalyzerForField("title", keyword).
+		WithDefaultAnalyzer(simple).
+		WithDebugAnalyzer(true).
+		WithLogger(log.New(&logged, "", 0))
+
+	q, err := ParseQueryString(`title:"Hello World" "Hello World"`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchPhraseQuery("Hello World").
+			SetField("title").
+			SetAnalyzer(keyword)).
+		AddShould(bluge.NewMatchPhraseQuery("Hello World").
+			SetAnalyzer(simple))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	expectedLog := "specific analyzer used for field 'title'\n" +
+		"default analyzer used for field ''\n"
+	if logged.String() != expectedLog {
+		t.Errorf("Expected log %q, got %q", expectedLog, logged.String())
+	}
+}
+
+func TestQuerySyntaxParserDefaultFields(t *testing.T) {
+	keyword := analyzer.NewKeywordAnalyzer()
+	options := DefaultOptions().
+		WithAnalyzerForField("title", keyword).
+		WithFieldType("price", NumericField).
+		WithDefaultFields("title^3", "body", "price")
+
+	q, err := ParseQueryString(`golang tips~1 tag:go 5`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewMatchQuery("golang").
+				SetField("title").
+				SetAnalyzer(keyword).
+				SetBoost(3)).
+			AddShould(bluge.NewMatchQuery("golang").
+				SetField("body"))).
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewMatchQuery("tips").
+				SetFuzziness(1).
+				SetField("title").
+				SetAnalyzer(keyword).
+				SetBoost(3)).
+			AddShould(bluge.NewMatchQuery("tips").
+				SetFuzziness(1).
+				SetField("body"))).
+		AddShould(bluge.NewMatchQuery("go").
+			SetField("tag")).
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("5").
+					SetField("title").
+					SetAnalyzer(keyword)).
+				AddShould(bluge.NewNumericRangeInclusiveQuery(5, 5, true, true).
+					SetField("title")).
+				SetBoost(3)).
+			AddShould(bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("5").
+					SetField("body")).
+				AddShould(bluge.NewNumericRangeInclusiveQuery(5, 5, true, true).
+					SetField("body"))).
+			AddShould(bluge.NewNumericRangeInclusiveQuery(5, 5, true, true).
+				SetField("price")))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	// a boosted clause searching a single boosted field
+	q, err = ParseQueryString(`golang^2`, options.WithDefaultFields("title^3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery("golang").
+			SetField("title").
+			SetAnalyzer(keyword).
+			SetBoost(6))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	_, err = ParseQueryString(`golang`, options.WithDefaultFields("price"))
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Code != ErrorCodeInvalidValue {
+		t.Errorf("expected invalid value error, got %v", err)
+	}
+}
+
+func TestQuerySyntaxParserDefaultOperator(t *testing.T) {
+	options := DefaultOptions().WithDefaultOperator(OperatorAnd)
+	tests := []struct {
+		input  string
+		result bluge.Query
+	}{
+		{
+			input: `red shoes`,
+			result: bluge.NewBooleanQuery().
+				AddMust(bluge.NewMatchQuery("red")).
+				AddMust(bluge.NewMatchQuery("shoes")),
+		},
+		{
+			input: `+red -blue (shoes boots)`,
+			result: bluge.NewBooleanQuery().
+				AddMust(bluge.NewMatchQuery("red")).
+				AddMust(bluge.NewBooleanQuery().
+					AddMust(bluge.NewMatchQuery("shoes")).
+					AddMust(bluge.NewMatchQuery("boots"))).
+				AddMustNot(bluge.NewMatchQuery("blue")),
+		},
+		{
+			input: `red OR blue shoes`,
+			result: bluge.NewBooleanQuery().
+				AddMust(bluge.NewBooleanQuery().
+					AddShould(bluge.NewMatchQuery("red")).
+					AddShould(bluge.NewMatchQuery("blue"))).
+				AddMust(bluge.NewMatchQuery("shoes")),
+		},
+	}
+
+	for _, test := range tests {
+		q, err := ParseQueryString(test.input, options)
+		if err != nil {
+			t.Fatalf("unexpected error for `%s`: %v", test.input, err)
+		}
+		if !reflect.DeepEqual(q, test.result) {
+			t.Errorf("Expected %#v, got %#v for `%s`", test.result, q, test.input)
+		}
+	}
+}
+
+func TestQuerySyntaxParserMinShouldMatch(t *testing.T) {
+	options := DefaultOptions().WithMinShouldMatch("2<75%")
+
+	q, err := ParseQueryString(`a b c d (e f) (g h)@1`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery("a")).
+		AddShould(bluge.NewMatchQuery("b")).
+		AddShould(bluge.NewMatchQuery("c")).
+		AddShould(bluge.NewMatchQuery("d")).
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewMatchQuery("e")).
+			AddShould(bluge.NewMatchQuery("f")).
+			SetMinShould(2)).
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewMatchQuery("g")).
+			AddShould(bluge.NewMatchQuery("h")).
+			SetMinShould(1)).
+		SetMinShould(4)
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	tests := []struct {
+		spec    string
+		shoulds int
+		min     int
+	}{
+		{spec: "3", shoulds: 5, min: 3},
+		{spec: "3", shoulds: 2, min: 2},
+		{spec: "-2", shoulds: 5, min: 3},
+		{spec: "75%", shoulds: 5, min: 3},
+		{spec: "-25%", shoulds: 5, min: 4},
+		{spec: "-25%", shoulds: 2, min: 2},
+		{spec: "3<90%", shoulds: 3, min: 3},
+		{spec: "3<90%", shoulds: 10, min: 9},
+		{spec: "2<-25% 9<-3", shoulds: 2, min: 2},
+		{spec: "2<-25% 9<-3", shoulds: 8, min: 6},
+		{spec: "2<-25% 9<-3", shoulds: 12, min: 9},
+	}
+	for _, test := range tests {
+		min, err := queryStringMinShould(test.spec, test.shoulds)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", test.spec, err)
+		} else if min != test.min {
+			t.Errorf("expected %d of %d for '%s', got %d", test.min, test.shoulds, test.spec, min)
+		}
+	}
+
+	for _, spec := range []string{"", "x", "150%", "3<", "5<1 2<1", "1 2"} {
+		if _, err := queryStringMinShould(spec, 4); err == nil {
+			t.Errorf("expected error for '%s'", spec)
+		}
+	}
+
+	// chains of OR mean the same as clauses without operators
+	options = DefaultOptions().WithMinShouldMatch("2")
+	for _, input := range []string{`a b c`, `a OR b OR c`, `a || b c`} {
+		q, err = ParseQueryString(input, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		min := q.(*bluge.BooleanQuery).MinShould()
+		if input != `a b c` {
+			min = q.(*bluge.BooleanQuery).Shoulds()[0].(*bluge.BooleanQuery).MinShould()
+		}
+		if min != 2 {
+			t.Errorf("expected 2 clauses to have to match, got %d for `%s`", min, input)
+		}
+	}
+
+	_, err = ParseQueryString(`(a b)@x`, DefaultOptions())
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Code != ErrorCodeInvalidValue || pe.Offset != 5 {
+		t.Errorf("expected invalid value error at offset 5, got %v", err)
+	}
+}
+
+func TestQuerySyntaxParserExistsQuery(t *testing.T) {
+	options := DefaultOptions().
+		WithExistsQuery(func(field string) (bluge.Query, error) {
+			if field == "secret" {
+				return nil, errors.New("no such field")
+			}
+			return bluge.NewTermQuery(field).SetField("_fields"), nil
+		})
+
+	q, err := ParseQueryString(`_exists_:title`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewTermQuery("title").SetField("_fields"))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	_, err = ParseQueryString(`a _missing_:secret`, options)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset != 2 {
+		t.Errorf("expected error at offset 2, got %v", err)
+	}
+}
+
+func TestQuerySyntaxParserEmpty(t *testing.T) {
+	for _, input := range []string{"", "  "} {
+		q, err := ParseQueryString(input, DefaultOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(q, bluge.NewMatchNoneQuery()) {
+			t.Errorf("Expected match none, got %#v for `%s`", q, input)
+		}
+
+		q, err = ParseQueryString(input, DefaultOptions().WithEmptyMatchesAll(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(q, bluge.NewMatchAllQuery()) {
+			t.Errorf("Expected match all, got %#v for `%s`", q, input)
+		}
+	}
+}
+
+func TestQuerySyntaxParserNegativeGroups(t *testing.T) {
+	input := `+status:open (-tag:spam -tag:junk) NOT a AND NOT b`
+	group := bluge.NewBooleanQuery().
+		AddMustNot(bluge.NewMatchQuery("spam").SetField("tag")).
+		AddMustNot(bluge.NewMatchQuery("junk").SetField("tag"))
+	chain := bluge.NewBooleanQuery().
+		AddMustNot(bluge.NewMatchQuery("a")).
+		AddMustNot(bluge.NewMatchQuery("b"))
+
+	q, err := ParseQueryString(input, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddMust(bluge.NewMatchQuery("open").SetField("status")).
+		AddShould(group).
+		AddShould(chain)
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	q, err = ParseQueryString(input, DefaultOptions().WithNegativeGroupsMatchAll(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.AddMust(bluge.NewMatchAllQuery())
+	chain.AddMust(bluge.NewMatchAllQuery())
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+}
+
+func TestQuerySyntaxParserFieldPatterns(t *testing.T) {
+	keyword := analyzer.NewKeywordAnalyzer()
+	options := DefaultOptions().
+		WithFieldType("title.en", TextField).
+		WithFieldType("title.de", TextField).
+		WithFieldType("body", TextField).
+		WithAnalyzerForField("title.de", keyword)
+
+	q, err := ParseQueryString(`title.*:foo *:*`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewMatchQuery("foo").
+				SetField("title.de").
+				SetAnalyzer(keyword)).
+			AddShould(bluge.NewMatchQuery("foo").
+				SetField("title.en"))).
+		AddShould(bluge.NewMatchAllQuery())
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	q, err = ParseQueryString(`bar`, options.WithDefaultFields("title.e?^2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery("bar").
+			SetField("title.en").
+			SetBoost(2))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	q, err = ParseQueryString(`_exists_:name.*`, options.WithFieldPatterns(func(pattern string) []string {
+		return []string{"name.first", "name.last"}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = bluge.NewBooleanQuery().
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewPrefixQuery("").SetField("name.first")).
+			AddShould(bluge.NewPrefixQuery("").SetField("name.last")))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	_, err = ParseQueryString(`a name.*:foo`, options)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Code != ErrorCodeUnsupported || pe.Offset != 2 {
+		t.Errorf("expected unsupported error at offset 2, got %v", err)
+	}
+}
+
+func TestQuerySyntaxParserFieldAliases(t *testing.T) {
+	keyword := analyzer.NewKeywordAnalyzer()
+	options := DefaultOptions().
+		WithFieldAlias("author", "meta.author_name").
+		WithFieldAlias("name", "meta.first_name", "meta.last_name").
+		WithFieldMapping(func(field string) []string {
+			if strings.HasPrefix(field, "x_") {
+				return []string{"ext." + field[2:]}
+			}
+			return nil
+		}).
+		WithFieldType("ts_created", DateField).
+		WithFieldAlias("created", "ts_created").
+		WithAnalyzerForField("meta.author_name", keyword)
+
+	q, err := ParseQueryString(`author:Smith name:jo* created:>"2006-01-02T15:04:05Z" x_color:red title:x`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	theDate, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery("Smith").
+			SetField("meta.author_name").
+			SetAnalyzer(keyword)).
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewWildcardQuery("jo*").SetField("meta.first_name")).
+			AddShould(bluge.NewWildcardQuery("jo*").SetField("meta.last_name"))).
+		AddShould(bluge.NewDateRangeInclusiveQuery(theDate, time.Time{}, false, true).
+			SetField("ts_created")).
+		AddShould(bluge.NewMatchQuery("red").SetField("ext.color")).
+		AddShould(bluge.NewMatchQuery("x").SetField("title"))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+}
+
+func TestQuerySyntaxParserFieldRestrictions(t *testing.T) {
+	options := DefaultOptions().
+		WithFieldType("title.en", TextField).
+		WithFieldType("title.secret", TextField).
+		WithFieldAlias("name", "owner_name").
+		WithAllowedFields("title", "title.*", "name", "price", "body").
+		WithDeniedFields("title.secret").
+		WithFieldFilter(func(field string) bool {
+			return !strings.HasPrefix(field, "_")
+		})
+
+	q, err := ParseQueryString(`title:x title.*:y name:z body:"a b"`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery("x").SetField("title")).
+		AddShould(bluge.NewMatchQuery("y").SetField("title.en")).
+		AddShould(bluge.NewMatchQuery("z").SetField("owner_name")).
+		AddShould(bluge.NewMatchPhraseQuery("a b").SetField("body"))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	tests := []struct {
+		input  string
+		field  string
+		offset int
+	}{
+		{input: `secret:x`, field: "secret"},
+		{input: `a title.secret:"x y"`, field: "title.secret", offset: 2},
+		{input: `a _id:>5`, field: "_id", offset: 2},
+		{input: `a secret:[a TO b]`, field: "secret", offset: 2},
+		{input: `a secret:/x.*/`, field: "secret", offset: 2},
+		{input: `a secret:x*`, field: "secret", offset: 2},
+		{input: `a (secret:(x y))`, field: "secret", offset: 11},
+		{input: `a _exists_:secret`, field: "secret", offset: 2},
+	}
+	for _, test := range tests {
+		_, err := ParseQueryString(test.input, options.WithLenient(true))
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("expected parse error for `%s`, got %v", test.input, err)
+		}
+		if perr.Code != ErrorCodeFieldNotAllowed || perr.Field != test.field || perr.Offset != test.offset {
+			t.Errorf("expected field %s not allowed at %d, got %#v for `%s`", test.field, test.offset, perr, test.input)
+		}
+	}
+
+	// denied fields are never searched, whatever stands for them
+	denied := DefaultOptions().
+		WithDeniedFields("_tenant").
+		WithFieldAlias("ten", "_tenant").
+		WithFieldAlias("who", "owner", "_tenant").
+		WithFieldMapping(func(field string) []string {
+			if strings.HasPrefix(field, "x_") {
+				return []string{"_" + field[2:]}
+			}
+			return nil
+		})
+	for _, test := range []struct {
+		input   string
+		options QueryStringOptions
+		field   string
+	}{
+		{input: `ten:x`, options: denied, field: "ten"},
+		{input: `x_tenant:x`, options: denied, field: "x_tenant"},
+		{input: `x`, options: denied.WithDefaultFields("_tenant"), field: "_tenant"},
+		{input: `x`, options: denied.WithDefaultFields("ten"), field: "ten"},
+	} {
+		_, err := ParseQueryString(test.input, test.options)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Code != ErrorCodeFieldNotAllowed || perr.Field != test.field {
+			t.Errorf("expected field %s not allowed, got %v for `%s`", test.field, err, test.input)
+		}
+	}
+
+	q, err = ParseQueryString(`x who:y`, denied.WithDefaultFields("_tenant", "t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery("x").SetField("t")).
+		AddShould(bluge.NewMatchQuery("y").SetField("owner"))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+}
+
+func TestQuerySyntaxParserDisabledFeatures(t *testing.T) {
+	options := DefaultOptions().
+		WithFieldType("price", NumericField).
+		WithDisabledFeatures(FeatureRegexp | FeatureLeadingWildcard | FeatureFuzzy | FeatureRange | FeatureBoost)
+
+	q, err := ParseQueryString(`wild* title:(te?t "a b") *`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewWildcardQuery("wild*")).
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewWildcardQuery("te?t").SetField("title")).
+			AddShould(bluge.NewMatchPhraseQuery("a b").SetField("title"))).
+		AddShould(bluge.NewMatchAllQuery())
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+
+	tests := []struct {
+		input   string
+		message string
+		token   string
+	}{
+		{input: `a /x.*/`, message: "regular expressions are disabled", token: "/x.*/"},
+		{input: `a title:*x`, message: "leading wildcards are disabled", token: "*x"},
+		{input: `a title:(b ?x)`, message: "leading wildcards are disabled", token: "?x"},
+		{input: `title:(*)`, message: "leading wildcards are disabled", token: "*"},
+		{input: `title:(a *)`, message: "leading wildcards are disabled", token: "*"},
+		{input: `a b~2`, message: "fuzzy terms are disabled", token: "b~2"},
+		{input: `a price:[1 TO 5]`, message: "ranges are disabled", token: "[1 TO 5]"},
+		{input: `a price:>=5`, message: "ranges are disabled", token: ">=5"},
+		{input: `a (b c)^2`, message: "boosts are disabled", token: "(b c)^2"},
+	}
+	for _, test := range tests {
+		_, err := ParseQueryString(test.input, options)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("expected parse error for `%s`, got %v", test.input, err)
+		}
+		if perr.Code != ErrorCodeDisabled || perr.Message != test.message || perr.Token != test.token {
+			t.Errorf("expected %s at %s, got %#v for `%s`", test.message, test.token, perr, test.input)
+		}
+	}
+
+	for _, input := range []string{`wild*`, `title:(*)`, `title:(a *)`} {
+		_, err = ParseQueryString(input, options.WithDisabledFeatures(FeatureWildcard))
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Message != "wildcards are disabled" {
+			t.Errorf("expected wildcards to be disabled, got %v for `%s`", err, input)
+		}
+	}
+
+	for _, input := range []string{`_exists_:title`, `-_missing_:title`, `title:[* TO *]`} {
+		_, err = ParseQueryString(input, options.WithDisabledFeatures(FeatureExists))
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Message != "exists queries are disabled" {
+			t.Errorf("expected exists queries to be disabled, got %v for `%s`", err, input)
+		}
+	}
+
+	q, warnings, err := ParseQueryStringWithWarnings(`/x.*/ b~2 price:[1 TO 5] c^2 *d (e f)^3`,
+		options.WithDisabledFeaturesAsLiteral(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery("/x.*/")).
+		AddShould(bluge.NewMatchQuery("b~2")).
+		AddShould(bluge.NewMatchQuery("price:[1 TO 5]")).
+		AddShould(bluge.NewMatchQuery("c")).
+		AddShould(bluge.NewMatchQuery("*d")).
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewMatchQuery("e")).
+			AddShould(bluge.NewMatchQuery("f")))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+	literals := []string{"/x.*/", "b~2", "price:[1 TO 5]", "", "*d", ""}
+	if len(warnings) != len(literals) {
+		t.Fatalf("expected %d warnings for disabled syntax, got %v", len(literals), warnings)
+	}
+	for i, w := range warnings {
+		if w.Literal != literals[i] || w.Cause.Code != ErrorCodeDisabled {
+			t.Errorf("expected warning for '%s', got %v", literals[i], w)
+		}
+	}
+	if warnings[3].Offset != 26 || warnings[3].Cause.Token != "^2" {
+		t.Errorf("expected the boost to be left out, got %#v", warnings[3].Cause)
+	}
+
+	// only the value is made literal, it is still searched for in the field
+	q, warnings, err = ParseQueryStringWithWarnings(`title:*x title:(a /b/) _exists_:f`,
+		options.WithDisabledFeatures(FeatureLeadingWildcard|FeatureRegexp|FeatureExists).WithDisabledFeaturesAsLiteral(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery("*x").SetField("title")).
+		AddShould(bluge.NewBooleanQuery().
+			AddShould(bluge.NewMatchQuery("a").SetField("title")).
+			AddShould(bluge.NewMatchQuery("/b/").SetField("title"))).
+		AddShould(bluge.NewMatchQuery("f").SetField("_exists_"))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+	literals = []string{"*x", "/b/", "f"}
+	if len(warnings) != len(literals) {
+		t.Fatalf("expected %d warnings for disabled syntax, got %v", len(literals), warnings)
+	}
+	for i, w := range warnings {
+		if w.Literal != literals[i] || w.Cause.Code != ErrorCodeDisabled {
+			t.Errorf("expected warning for '%s', got %v", literals[i], w)
+		}
+	}
+
+	_, err = ParseQueryString(`/x.*/ field::text`, options.WithDisabledFeaturesAsLiteral(true))
+	if err == nil {
+		t.Errorf("expected syntax error to fail")
+	}
+}
+
+func TestQuerySyntaxParserLimits(t *testing.T) {
+	options := DefaultOptions().
+		WithMaxLength(40).
+		WithMaxClauses(4).
+		WithMaxDepth(2).
+		WithMaxPhraseTerms(3).
+		WithMaxFuzziness(1).
+		WithLenient(true)
+
+	q, err := ParseQueryString(`a ("c d e" AND g~1) b`, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q == nil {
+		t.Fatal("expected a query")
+	}
+
+	tests := []struct {
+		input   string
+		message string
+		offset  int
+	}{
+		{input: `a b c d e f g h i j k l m n o p q r s t u`, message: "query string is longer than 40 bytes", offset: 40},
+		{input: `a b (c d) e`, message: "query has more than 4 clauses", offset: 10},
+		{input: `a ((b (c)))`, message: "groups are nested more than 2 deep", offset: 6},
+		{input: `a AND (b OR (c AND d))`, message: "groups are nested more than 2 deep", offset: 7},
+		{input: `a "b c d e"`, message: "phrase has more than 3 terms", offset: 2},
+		{input: `a title:b~2`, message: "fuzziness is more than 1", offset: 2},
+	}
+	for _, test := range tests {
+		_, err := ParseQueryString(test.input, options)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("expected parse error for `%s`, got %v", test.input, err)
+		}
+		if perr.Code != ErrorCodeLimit || perr.Message != test.message || perr.Offset != test.offset {
+			t.Errorf("expected %s at %d, got %#v for `%s`", test.message, test.offset, perr, test.input)
+		}
+	}
+
+	_, err = ParseQueryString(`aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaé`, options)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Offset != 39 || perr.Column != 40 {
+		t.Errorf("expected limit error at the start of the last rune, got %#v", err)
+	}
+
+	// clauses are counted after fields are expanded
+	schema := DefaultOptions()
+	for _, field := range []string{"a1", "a2", "a3", "a4", "a5", "a6", "a7", "a8"} {
+		schema = schema.WithFieldType(field, TextField)
+	}
+	for _, test := range []struct {
+		input   string
+		options QueryStringOptions
+	}{
+		{input: `a*:(x y)`, options: schema.WithMaxClauses(2)},
+		{input: `x`, options: schema.WithDefaultFields("a*").WithMaxClauses(1)},
+		{input: `x`, options: schema.WithFieldAlias("x", "a1", "a2").WithDefaultFields("x").WithMaxClauses(1)},
+	} {
+		_, err = ParseQueryString(test.input, test.options)
+		if !errors.As(err, &perr) || perr.Code != ErrorCodeLimit {
+			t.Errorf("expected clause limit error, got %v for `%s`", err, test.input)
+		}
+	}
+	if _, err = ParseQueryString(`a*:x`, schema.WithMaxClauses(8)); err != nil {
+		t.Errorf("expected 8 clauses to be allowed, got %v", err)
+	}
+
+	// limits apply to blank query strings and syntax trees too
+	if _, err = ParseQueryString(strings.Repeat(" ", 50), options); !errors.As(err, &perr) || perr.Code != ErrorCodeLimit {
+		t.Errorf("expected length limit error for blank query string, got %v", err)
+	}
+	if _, err = ParseAST(strings.Repeat("\t", 50), options); !errors.As(err, &perr) || perr.Code != ErrorCodeLimit {
+		t.Errorf("expected length limit error for blank syntax tree, got %v", err)
+	}
+	for _, test := range []struct {
+		input   string
+		options QueryStringOptions
+	}{
+		{input: `a b (c d) e`, options: options},
+		{input: `a*:(x y)`, options: schema.WithMaxClauses(2)},
+		{input: `x`, options: schema.WithDefaultFields("a*").WithMaxClauses(1)},
+	} {
+		_, err = ParseAST(test.input, test.options)
+		if !errors.As(err, &perr) || perr.Code != ErrorCodeLimit {
+			t.Errorf("expected clause limit error for syntax tree, got %v for `%s`", err, test.input)
+		}
+	}
+}
+
+func TestQuerySyntaxParserErrors(t *testing.T) {
+	options := DefaultOptions().WithFieldType("price", NumericField)
+	tests := []struct {
+		input  string
+		errors []*ParseError
+	}{
+		{
+			input: `field::text`,
+			errors: []*ParseError{
+				{
+					Code:     ErrorCodeSyntax,
+					Message:  "syntax error: unexpected ':', expecting term or phrase or '-' or number or '>' or '<' or '(' or '[' or '{'",
+					Token:    ":",
+					Offset:   6,
+					Line:     1,
+					Column:   7,
+					Expected: []string{"term", "phrase", "'-'", "number", "'>'", "'<'", "'('", "'['", "'{'"},
+				},
+			},
+		},
+		{
+			input: `a AND`,
+			errors: []*ParseError{
+				{
+					Code:     ErrorCodeSyntax,
+					Message:  "syntax error: unexpected end of input, expecting '+' or '-' or NOT or term or phrase or number or '>' or '<' or '(' or '[' or '{'",
+					Token:    "",
+					Offset:   5,
+					Line:     1,
+					Column:   6,
+					Expected: []string{"'+'", "'-'", "NOT", "term", "phrase", "number", "'>'", "'<'", "'('", "'['", "'{'"},
+				},
+			},
+		},
+		{
+			input: `café title:"unterminated`,
+			errors: []*ParseError{
+				{
+					Code:    ErrorCodeUnterminatedQuote,
+					Message: "unterminated quote",
+					Token:   `"unterminated`,
+					Offset:  12,
+					Line:    1,
+					Column:  12,
+				},
+			},
+		},
+		{
+			input: `price:cheap +price:[1 TO 5x] boosted^high`,
+			errors: []*ParseError{
+				{
+					Code:    ErrorCodeInvalidValue,
+					Message: `invalid value 'cheap' for numeric field 'price': strconv.ParseFloat: parsing "cheap": invalid syntax`,
+					Token:   "price:cheap",
+					Offset:  0,
+					Line:    1,
+					Column:  1,
+				},
+				{
+					Code:    ErrorCodeInvalidValue,
+					Message: `error parsing number: strconv.ParseFloat: parsing "5x": invalid syntax`,
+					Token:   "price:[1 TO 5x]",
+					Offset:  13,
+					Line:    1,
+					Column:  14,
+				},
+				{
+					Code:    ErrorCodeInvalidValue,
+					Message: `invalid boost value: strconv.ParseFloat: parsing "high": invalid syntax`,
+					Token:   "^high",
+					Offset:  36,
+					Line:    1,
+					Column:  37,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := ParseQueryString(test.input, options)
+		errs, ok := err.(ParseErrors)
+		if !ok {
+			t.Fatalf("expected ParseErrors, got %#v for `%s`", err, test.input)
+		}
+		for _, e := range test.errors {
+			e.end = e.Offset + len(e.Token)
+		}
+		if !reflect.DeepEqual([]*ParseError(errs), test.errors) {
+			t.Errorf("\nexpected: %#v\n     got: %#v\n for `%s`", test.errors, errs, test.input)
+		}
+	}
+
+	_, err := ParseQueryString(`a:b^c`, options)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset != 3 {
+		t.Errorf("expected errors.As to find the ParseError, got %#v", pe)
+	}
+}
+
+func TestQuerySyntaxParserLenient(t *testing.T) {
+	options := DefaultOptions().
+		WithLenient(true).
+		WithFieldType("price", NumericField)
+	tests := []struct {
+		input    string
+		result   bluge.Query
+		warnings []*Warning
+	}{
+		{
+			input: `field::text`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("field::text")),
+			warnings: []*Warning{
+				{Literal: "field::text", Offset: 0, Cause: &ParseError{Code: ErrorCodeSyntax}},
+			},
+		},
+		{
+			input: `black AND`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("black")).
+				AddShould(bluge.NewMatchQuery("AND")),
+			warnings: []*Warning{
+				{Literal: "AND", Offset: 6, Cause: &ParseError{Code: ErrorCodeSyntax}},
+			},
+		},
+		{
+			input: `(c++ tips`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("(c++ tips")),
+			warnings: []*Warning{
+				{Literal: "(c++ tips", Offset: 0, Cause: &ParseError{Code: ErrorCodeSyntax}},
+			},
+		},
+		{
+			input: `title:"the end`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("\"the end").SetField("title")),
+			warnings: []*Warning{
+				{Literal: "\"the end", Offset: 6, Cause: &ParseError{Code: ErrorCodeUnterminatedQuote}},
+			},
+		},
+		{
+			input: `price:cheap +price:[1 TO 5x] -wild*`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("price:cheap")).
+				AddMust(bluge.NewMatchQuery("price:[1 TO 5x]")).
+				AddMustNot(bluge.NewWildcardQuery("wild*")),
+			warnings: []*Warning{
+				{Literal: "price:cheap", Offset: 0, Cause: &ParseError{Code: ErrorCodeInvalidValue}},
+				{Literal: "price:[1 TO 5x]", Offset: 13, Cause: &ParseError{Code: ErrorCodeInvalidValue}},
+			},
+		},
+		// several errors in a single word
+		{
+			input: `>"a b"-`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery(`>"a b"-`)),
+			warnings: []*Warning{
+				{Literal: `>"a b"-`, Offset: 0, Cause: &ParseError{Code: ErrorCodeSyntax}},
+			},
+		},
+		{
+			input: `x ||-(`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery("x")).
+				AddShould(bluge.NewMatchQuery("||-(")),
+			warnings: []*Warning{
+				{Literal: "||-(", Offset: 2, Cause: &ParseError{Code: ErrorCodeSyntax}},
+			},
+		},
+		{
+			input: `:?x:>=`,
+			result: bluge.NewBooleanQuery().
+				AddShould(bluge.NewMatchQuery(":?x:>=")),
+			warnings: []*Warning{
+				{Literal: ":?x:>=", Offset: 0, Cause: &ParseError{Code: ErrorCodeSyntax}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		q, warnings, err := ParseQueryStringWithWarnings(test.input, options)
+		if err != nil {
+			t.Fatalf("unexpected error for `%s`: %v", test.input, err)
+		}
+		if !reflect.DeepEqual(q, test.result) {
+			t.Errorf("\nexpected: %#v\n     got: %#v\n for `%s`", test.result, q, test.input)
+		}
+		if len(warnings) != len(test.warnings) {
+			t.Fatalf("expected %d warnings, got %v for `%s`", len(test.warnings), warnings, test.input)
+		}
+		for i, w := range warnings {
+			if w.Literal != test.warnings[i].Literal || w.Offset != test.warnings[i].Offset ||
+				w.Cause.Code != test.warnings[i].Cause.Code {
+				t.Errorf("expected warning %v, got %v for `%s`", test.warnings[i], w, test.input)
+			}
+		}
+	}
+
+	_, err := ParseQueryString(`field::text`, options.WithLenient(false))
+	if err == nil {
+		t.Errorf("expected error when not lenient")
+	}
+}
+
+func TestQuerySyntaxParserLenientLarge(t *testing.T) {
+	options := DefaultOptions().WithLenient(true)
+
+	// every word is a syntax error, which would take a
+	// pass each if they were found one at a time
+	query := strings.Repeat("a:b:c ", 20000)
+	start := time.Now()
+	_, warnings, err := ParseQueryStringWithWarnings(query, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 20000 {
+		t.Errorf("expected 20000 warnings, got %d", len(warnings))
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected large query string to parse quickly, took %v", elapsed)
+	}
+
+	// errors only found once the ones before them are made
+	// literal leave the whole query string searched as text
+	query = strings.Repeat("AND ", 100)
+	q, warnings, err := ParseQueryStringWithWarnings(query, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery(strings.TrimSpace(query)))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, q)
+	}
+	if len(warnings) != 1 || warnings[0].Offset != 0 {
+		t.Errorf("expected a single warning for the whole query string, got %v", warnings)
+	}
+}
+
+func TestParseAST(t *testing.T) {
+	boost := 3.0
+	input := `title:"big cat"~2 -(a OR b*)^3 price:>=5 /re/`
+	expected := &BooleanNode{
+		Clauses: []*Clause{
+			{
+				Occur: OccurShould,
+				Node:  &PhraseNode{Field: "title", Phrase: "big cat", Slop: 2, Span: Span{Start: 0, End: 17}},
+			},
+			{
+				Occur: OccurMustNot,
+				Node: &BooleanNode{
+					Clauses: []*Clause{
+						{
+							Occur: OccurShould,
+							Node: &BooleanNode{
+								Clauses: []*Clause{
+									{Occur: OccurShould, Node: &TermNode{Term: "a", Span: Span{Start: 20, End: 21}}},
+									{Occur: OccurShould, Node: &WildcardNode{Pattern: "b*", Span: Span{Start: 25, End: 27}}},
+								},
+								Span: Span{Start: 20, End: 27},
+							},
+						},
+					},
+					Span: Span{Start: 19, End: 28},
+				},
+				Boost: &boost,
+			},
+			{
+				Occur: OccurShould,
+				Node: &RangeNode{
+					Field:        "price",
+					Min:          &RangeBound{Kind: NumberValue, Value: "5"},
+					MinInclusive: true,
+					Span:         Span{Start: 31, End: 40},
+				},
+			},
+			{
+				Occur: OccurShould,
+				Node:  &RegexpNode{Pattern: "re", Span: Span{Start: 41, End: 45}},
+			},
+		},
+		Span: Span{Start: 0, End: 45},
+	}
+
+	root, err := ParseAST(input, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(root, expected) {
+		t.Errorf("\nexpected: %#v\n     got: %#v", expected, root)
+	}
+	if root.String() != `title:"big cat"~2 -((a b*))^3 price:>=5 /re/` {
+		t.Errorf("unexpected string for tree: %s", root)
+	}
+
+	q, err := Compile(root, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct, err := ParseQueryString(input, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(q, direct) {
+		t.Errorf("\nexpected: %#v\n     got: %#v", direct, q)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	options := DefaultOptions().
+		WithFieldType("sku", KeywordField).
+		WithFieldType("price", NumericField)
+
+	root := &BooleanNode{
+		Clauses: []*Clause{
+			{Occur: OccurMust, Node: &TermNode{Field: "sku", Term: "AB-1"}},
+			{Occur: OccurShould, Node: &RangeNode{Field: "price", Max: &RangeBound{Kind: NumberValue, Value: "10"}}},
+		},
+	}
+	q, err := Compile(root, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bluge.NewBooleanQuery().
+		AddMust(bluge.NewTermQuery("AB-1").SetField("sku")).
+		AddShould(bluge.NewNumericRangeInclusiveQuery(bluge.MinNumeric, 10, true, false).SetField("price"))
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("\nexpected: %#v\n     got: %#v", expected, q)
+	}
+
+	root.Clauses = append(root.Clauses, &Clause{
+		Node: &RangeNode{Field: "price", Min: &RangeBound{Value: "cheap"}, Span: Span{Start: 7, End: 18}},
+	})
+	_, err = Compile(root, options)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Code != ErrorCodeInvalidValue || pe.Offset != 7 {
+		t.Errorf("expected invalid value error at offset 7, got %v", err)
+	}
+
+	// syntax trees built by hand may be missing parts
+	for _, node := range []Node{
+		nil,
+		(*TermNode)(nil),
+		&BooleanNode{Clauses: []*Clause{{Node: nil}}},
+		&BooleanNode{Clauses: []*Clause{nil}},
+		&RangeNode{Min: &RangeBound{Kind: NumberValue, Value: "1"}},
+		&ExistsNode{},
+		&BooleanNode{Clauses: []*Clause{{Node: &ExistsNode{Missing: true}}}},
+	} {
+		_, err = Compile(node, options.WithDefaultFields("sku"))
+		if !errors.As(err, &pe) || pe.Code != ErrorCodeUnsupported {
+			t.Errorf("expected unsupported error for %#v, got %v", node, err)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	options := DefaultOptions().
+		WithFieldType("sku", KeywordField).
+		WithFieldType("in_stock", BooleanField)
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  `title:"big cat"~2 -(a OR b*)^3 price:>=5 /re/`,
+			output: `title:"big cat"~2 price:>=5 /re/ -((a b*))^3`,
+		},
+		{
+			input:  `+foo -bar baz^1.5`,
+			output: `+foo baz^1.5 -bar`,
+		},
+		{
+			input:  `black AND white OR grey`,
+			output: `((+black +white) grey)`,
+		},
+		{
+			input:  `5 field:-3.5 age:[18 TO 30} weight:{1 TO *]`,
+			output: `5 field:-3.5 age:[18 TO 30} weight:>1`,
+		},
+		{
+			input:  `created:>"2006-01-02T15:04:05Z" name:[a TO m}`,
+			output: `created:>"2006-01-02T15:04:05Z" name:[a TO m}`,
+		},
+		{
+			input:  `word~2 sku:AB100~1 sku:AB100 in_stock:true`,
+			output: `word~2 sku:AB100~1 sku:AB100 in_stock:true`,
+		},
+		{
+			input:  `tag:x\:y c\+\+ "say \"hi\"" f?o* path:/a\/b/`,
+			output: `tag:x\:y c\+\+ "say \"hi\"" f?o* path:/a\/b/`,
+		},
+		{
+			input:  `(a b c)@2 -(d e)@1^2`,
+			output: `(a b c)@2 -(d e)@1^2`,
+		},
+		{
+			input:  `_exists_:title -_exists_:body _missing_:tags^2`,
+			output: `_exists_:title _missing_:tags^2 -_exists_:body`,
+		},
+		{
+			input:  `*:* -foo`,
+			output: `* -foo`,
+		},
+		{
+			input:  `f:x~2^3 f:"a b"~2^3 name:[\* TO z}`,
+			output: `f:x~2^3 f:"a b"~2^3 name:[\* TO z}`,
+		},
+		{
+			input:  "\\\t?< f:\\ a* \\1?",
+			output: "\\\t?\\< f:\\ a* \\1?",
+		},
+		{
+			input:  `_exists_:/ _missing_:/x\ y/ _exists_:\1`,
+			output: `_exists_:/ _missing_:/x\ y/ _exists_:\1`,
+		},
+	}
+
+	for _, test := range tests {
+		q, err := ParseQueryString(test.input, options)
+		if err != nil {
+			t.Fatalf("unexpected error for `%s`: %v", test.input, err)
+		}
+		output, err := Format(q, options)
+		if err != nil {
+			t.Fatalf("unexpected error formatting `%s`: %v", test.input, err)
+		}
+		if output != test.output {
+			t.Errorf("expected `%s`, got `%s` for `%s`", test.output, output, test.input)
+		}
+		again, err := ParseQueryString(output, options)
+		if err != nil {
+			t.Fatalf("unexpected error for `%s`: %v", output, err)
+		}
+		if !reflect.DeepEqual(again, q) {
+			t.Errorf("\nexpected: %#v\n     got: %#v\n for `%s`", q, again, output)
+		}
+	}
+
+	_, err := Format(bluge.NewNumericRangeQuery(1, 2), options)
+	if err == nil {
+		t.Errorf("expected error formatting range without field")
+	}
+
+	// boosts from default fields and bounds that are only a *
+	boosted, err := ParseQueryString(`a~2 "x y"~1`, options.WithDefaultFields("title^3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []bluge.Query{
+		boosted,
+		bluge.NewBooleanQuery().AddShould(bluge.NewTermRangeQuery("*", "z").SetField("name")),
+	} {
+		output, err := Format(q, options)
+		if err != nil {
+			t.Fatalf("unexpected error formatting %#v: %v", q, err)
+		}
+		again, err := ParseQueryString(output, options)
+		if err != nil {
+			t.Fatalf("unexpected error for `%s`: %v", output, err)
+		}
+		if !reflect.DeepEqual(again, q) {
+			t.Errorf("\nexpected: %#v\n     got: %#v\n for `%s`", q, again, output)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	theDate, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		builder *Builder
+		output  string
+	}{
+		{
+			builder: NewBuilder().
+				Must(Field("status", "open")).
+				Should(Phrase("title", "release notes").Boost(2)).
+				Range("price", 10, 20),
+			output: `+status:open title:"release notes"^2 price:[10 TO 20]`,
+		},
+		{
+			builder: NewBuilder().
+				Should(Term("AND"), Term("c++ (new)"), Field("tag:x", "1a")).
+				MustNot(Phrase("", `say "hi"`).Slop(2), Number("", -3), Number("size", -3.5)),
+			output: `\AND c\+\+\ \(new\) tag\:x:\1a -"say \"hi\""~2 -\-3 -size:-3.5`,
+		},
+		{
+			builder: NewBuilder().
+				Should(Fuzzy("name", "jon", 2), Wildcard("path", "a b*"), Regexp("code", "a/[0-9]+")).
+				Must(Group(NewBuilder().Should(Term("red"), Term("blue")).MustNot(Term("green"))).Boost(1.5)),
+			output: `name:jon~2 path:a\ b* code:/a\/\[0\-9\]\+/ +(red blue -green)^1.5`,
+		},
+		{
+			builder: NewBuilder().
+				Should(Group(NewBuilder().Should(Term("a"), Term("b"), Term("c"))).MinShould("2").Boost(2)),
+			output: `(a b c)@2^2`,
+		},
+		{
+			builder: NewBuilder().
+				Must(Exists("title")).
+				MustNot(Missing("tags")),
+			output: `+_exists_:title -_missing_:tags`,
+		},
+		{
+			builder: NewBuilder().
+				Must(MatchAll().Boost(2)).
+				MustNot(Term("*")),
+			output: `+*^2 -\*`,
+		},
+		{
+			builder: NewBuilder().
+				Should(GreaterThan("age", 18), GreaterThanOrEqual("age", 18.5), LessThan("name", "m"), LessThanOrEqual("created", theDate)).
+				Should(Range("age", nil, 30).Inclusive(false, false), Range("name", "a b", "z").Inclusive(true, false)),
+			output: `age:>18 age:>=18.5 name:<m created:<="2006-01-02T15:04:05Z" age:<30 name:[a\ b TO z}`,
+		},
+		{
+			builder: NewBuilder().
+				Should(Fuzzy("f", "x", 2).Boost(3), Phrase("f", "a b").Slop(2).Boost(3)).
+				Should(Range("f", nil, nil), Range("name", "*", "z")),
+			output: `f:x~2^3 f:"a b"~2^3 f:[* TO *] name:[\* TO z]`,
+		},
+	}
+
+	for _, test := range tests {
+		output, err := test.builder.Build()
+		if err != nil {
+			t.Fatalf("unexpected error building `%s`: %v", test.output, err)
+		}
+		if output != test.output {
+			t.Errorf("expected `%s`, got `%s`", test.output, output)
+		}
+		q, err := ParseQueryString(output, DefaultOptions())
+		if err != nil {
+			t.Fatalf("unexpected error for `%s`: %v", output, err)
+		}
+		built, err := Compile(test.builder.AST(), DefaultOptions())
+		if err != nil {
+			t.Fatalf("unexpected error compiling `%s`: %v", output, err)
+		}
+		if !reflect.DeepEqual(q, built) {
+			t.Errorf("\nexpected: %#v\n     got: %#v\n for `%s`", built, q, output)
+		}
+	}
+
+	for _, expr := range []*Expr{
+		Range("", 1, 2),
+		Range("f", math.Inf(-1), 2),
+		GreaterThan("f", math.NaN()),
+		Number("f", math.Inf(1)),
+		Number("", math.NaN()),
+		Exists(""),
+		Group(NewBuilder().Should(Term("a"), Missing(""))),
+		Term(""),
+		Field("x", ""),
+		Wildcard("x", ""),
+		Fuzzy("x", "", 1),
+		Phrase("x", "a b").Slop(-2),
+		Group(NewBuilder()),
+		Group(NewBuilder().Should(Term("a"), Term("b"))).MinShould("zz"),
+		Field("_exists_", "c"),
+		Wildcard("_missing_", "c*"),
+		Wildcard("", "*"),
+		Wildcard("x", "abc"),
+	} {
+		b := NewBuilder().Should(Term("ok"), expr)
+		if _, err := b.Build(); err == nil {
+			t.Errorf("expected error building `%s`", b)
+		}
+		if output := b.String(); output != "ok" && output != "ok (a)" {
+			t.Errorf("expected clause to be left out, got `%s`", output)
+		}
+	}
+
+	// text which reads as something else is found by parsing it again
+	b := NewBuilder().Should(Term("ok"))
+	b.AST().Clauses = append(b.AST().Clauses, &Clause{Node: &TermNode{Field: "_exists_", Term: "c"}})
+	if output, err := b.Build(); err == nil {
+		t.Errorf("expected error building `%s`", output)
+	}
+}
+
+func TestFormatDefaultOperatorAnd(t *testing.T) {
+	options := DefaultOptions().WithDefaultOperator(OperatorAnd)
+	tests := []struct {
+		input   string
+		options QueryStringOptions
+		output  string
+	}{
+		{
+			input:   `red OR blue -green shoes`,
+			options: options,
+			output:  `red OR blue +shoes -green`,
+		},
+		{
+			input:   `(red OR -blue)^2 -(a OR b)`,
+			options: options,
+			output:  `+(red OR -blue)^2 -(a OR b)`,
+		},
+		{
+			input:   `red || blue`,
+			options: options.WithKeywordOperators(false),
+			output:  `red || blue`,
+		},
+	}
+
+	for _, test := range tests {
+		q, err := ParseQueryString(test.input, test.options)
+		if err != nil {
+			t.Fatalf("unexpected error for `%s`: %v", test.input, err)
+		}
+		output, err := Format(q, test.options)
+		if err != nil {
+			t.Fatalf("unexpected error formatting `%s`: %v", test.input, err)
+		}
+		if output != test.output {
+			t.Errorf("expected `%s`, got `%s` for `%s`", test.output, output, test.input)
+		}
+		// groups may be nested differently, but format the same
+		again, err := ParseQueryString(output, test.options)
+		if err != nil {
+			t.Fatalf("unexpected error for `%s`: %v", output, err)
+		}
+		output, err = Format(again, test.options)
+		if err != nil || output != test.output {
+			t.Errorf("expected `%s`, got `%s` (%v) formatting again", test.output, output, err)
+		}
+	}
+
+	_, err := Format(bluge.NewBooleanQuery().
+		AddMust(bluge.NewMatchQuery("red")).
+		AddShould(bluge.NewMatchQuery("blue")), options)
+	if err == nil {
+		t.Errorf("expected error formatting optional clause alongside required one")
+	}
+}
+
+func TestFormatMinShouldMatch(t *testing.T) {
+	options := DefaultOptions().WithMinShouldMatch("2")
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  `a b c`,
+			output: `a b c`,
+		},
+		{
+			input:  `(a b c)`,
+			output: `(a b c)`,
+		},
+		{
+			input:  `a OR b OR c`,
+			output: `(a b c)`,
+		},
+		{
+			input:  `(a b c)@1 d`,
+			output: `(a b c)@1 d`,
+		},
+		{
+			input:  `a`,
+			output: `a`,
+		},
+	}
+
+	for _, test := range tests {
+		q, err := ParseQueryString(test.input, options)
+		if err != nil {
+			t.Fatalf("unexpected error for `%s`: %v", test.input, err)
+		}
+		output, err := Format(q, options)
+		if err != nil {
+			t.Fatalf("unexpected error formatting `%s`: %v", test.input, err)
+		}
+		if output != test.output {
+			t.Errorf("expected `%s`, got `%s` for `%s`", test.output, output, test.input)
+		}
+		again, err := ParseQueryString(output, options)
+		if err != nil {
+			t.Fatalf("unexpected error for `%s`: %v", output, err)
+		}
+		if !reflect.DeepEqual(q, again) {
+			t.Errorf("expected `%s` to parse the same as `%s`", output, test.input)
+		}
+	}
+
+	// optional clauses which need not all match are marked when the
+	// options would otherwise apply a minimum
+	q := bluge.NewBooleanQuery().
+		AddShould(bluge.NewMatchQuery("a")).
+		AddShould(bluge.NewMatchQuery("b"))
+	output, err := Format(q, options)
+	if err != nil || output != `(a b)@0` {
+		t.Errorf("expected `(a b)@0`, got `%s` (%v)", output, err)
+	}
+	again, err := ParseQueryString(output, options)
+	if err != nil || !reflect.DeepEqual(again, bluge.NewBooleanQuery().AddShould(q).SetMinShould(1)) {
+		t.Errorf("expected `%s` to parse as the group, got %v (%v)", output, again, err)
+	}
+}
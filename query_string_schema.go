@@ -0,0 +1,214 @@
+//  Copyright (c) 2020 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querystr
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blugelabs/bluge"
+)
+
+// FieldType describes the values indexed in a field
+type FieldType int
+
+const (
+	// UntypedField is used for fields not declared with WithFieldType,
+	// values are searched for as text, and numbers additionally as
+	// numeric values
+	UntypedField FieldType = iota
+	// TextField values are analyzed text
+	TextField
+	// KeywordField values are matched exactly, without analysis
+	KeywordField
+	// NumericField values are numbers
+	NumericField
+	// DateField values are dates, parsed using the date format option
+	DateField
+	// BooleanField values are the keywords true and false
+	BooleanField
+	// GeoField values are geo points, which cannot be searched
+	// for with the query string syntax
+	GeoField
+)
+
+func (t FieldType) String() string {
+	switch t {
+	case UntypedField:
+		return "untyped"
+	case TextField:
+		return "text"
+	case KeywordField:
+		return "keyword"
+	case NumericField:
+		return "numeric"
+	case DateField:
+		return "date"
+	case BooleanField:
+		return "boolean"
+	case GeoField:
+		return "geo"
+	}
+	return fmt.Sprintf("FieldType(%d)", int(t))
+}
+
+// searchesText reports whether fields of this type can be searched
+// with patterns like wildcards and regular expressions
+func (t FieldType) searchesText() bool {
+	return t == UntypedField || t == TextField || t == KeywordField
+}
+
+func fieldTypeForField(c *compiler, field string) FieldType {
+	return c.opt.fieldTypes[field]
+}
+
+// isFieldPattern reports whether a field name has wildcards
+func isFieldPattern(field string) bool {
+	return strings.ContainsAny(field, "*?")
+}
+
+// expandFields replaces aliases with the fields they stand for,
+// and field patterns with the fields they match, leaving out those
+// which may not be searched, it also returns the first field
+// given which stood for any of those
+func expandFields(c *compiler, fields []boostedField) (rv []boostedField, denied string) {
+	for _, f := range fields {
+		for _, name := range mapField(c, f.name) {
+			if !isFieldPattern(name) {
+				if name != "" && fieldDenied(c, name) {
+					if denied == "" {
+						denied = f.name
+					}
+					continue
+				}
+				rv = append(rv, boostedField{name: name, boost: f.boost})
+				continue
+			}
+			for _, match := range fieldsMatching(c, name) {
+				if fieldAllowed(c, match) {
+					rv = append(rv, boostedField{name: match, boost: f.boost})
+				}
+			}
+		}
+	}
+	return rv, denied
+}
+
+// mapField returns the fields in the index a field name stands for
+func mapField(c *compiler, field string) []string {
+	if fields, ok := c.opt.fieldAliases[field]; ok {
+		return fields
+	}
+	if c.opt.fieldMapping != nil && field != "" {
+		if fields := c.opt.fieldMapping(field); fields != nil {
+			return fields
+		}
+	}
+	return []string{field}
+}
+
+func fieldsMatching(c *compiler, pattern string) []string {
+	if c.opt.fieldPatterns != nil {
+		return c.opt.fieldPatterns(pattern)
+	}
+	re := fieldPatternRegexp(pattern)
+	var rv []string
+	for field := range c.opt.fieldTypes {
+		if re.MatchString(field) {
+			rv = append(rv, field)
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func fieldPatternRegexp(pattern string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+// fieldAllowed reports whether the options let the field be searched
+func fieldAllowed(c *compiler, field string) bool {
+	if c.opt.allowedFields != nil && !fieldListed(c.opt.allowedFields, field) {
+		return false
+	}
+	return !fieldDenied(c, field)
+}
+
+// fieldDenied reports whether the denied fields or the field filter
+// keep the field from being searched, which applies to the fields in
+// the index as well as to those written in the query string
+func fieldDenied(c *compiler, field string) bool {
+	if fieldListed(c.opt.deniedFields, field) {
+		return true
+	}
+	return c.opt.fieldFilter != nil && !c.opt.fieldFilter(field)
+}
+
+// fieldListed reports whether the field is one of those
+// given, or matches one of the field patterns given
+func fieldListed(fields map[string]bool, field string) bool {
+	if fields[field] {
+		return true
+	}
+	for name := range fields {
+		if isFieldPattern(name) && fieldPatternRegexp(name).MatchString(field) {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldNotAllowedError(field string) error {
+	rv := parseErrorf(ErrorCodeFieldNotAllowed, "field '%s' may not be searched", field)
+	rv.Field = field
+	return rv
+}
+
+func fieldTypeError(kind, field string, typ FieldType) error {
+	return parseErrorf(ErrorCodeFieldType, "%s queries are not supported on %s field '%s'", kind, typ, field)
+}
+
+// queryStringValue builds the query matching exactly one value
+// in a field declared to be of a type other than text
+func queryStringValue(c *compiler, field string, typ FieldType, str string) (bluge.Query, error) {
+	switch typ {
+	case KeywordField:
+		return bluge.NewTermQuery(str).SetField(field), nil
+	case NumericField:
+		val, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, parseErrorf(ErrorCodeInvalidValue, "invalid value '%s' for numeric field '%s': %v", str, field, err)
+		}
+		return bluge.NewNumericRangeInclusiveQuery(val, val, true, true).SetField(field), nil
+	case DateField:
+		t, err := queryTimeFromString(c, str)
+		if err != nil {
+			return nil, parseErrorf(ErrorCodeInvalidValue, "invalid value '%s' for date field '%s': %v", str, field, err)
+		}
+		return bluge.NewDateRangeInclusiveQuery(t, t, true, true).SetField(field), nil
+	case BooleanField:
+		val, err := strconv.ParseBool(str)
+		if err != nil {
+			return nil, parseErrorf(ErrorCodeInvalidValue, "invalid value '%s' for boolean field '%s'", str, field)
+		}
+		return bluge.NewTermQuery(strconv.FormatBool(val)).SetField(field), nil
+	}
+	return nil, fieldTypeError("value", field, typ)
+}